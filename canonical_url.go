@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// trackingParams are query parameters stripped from post and feed URLs.
+// Any parameter starting with "utm_" is stripped as well.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"gbraid":  true,
+	"wbraid":  true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+}
+
+func addTrackingParams(names []string) {
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			trackingParams[name] = true
+		}
+	}
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// canonicalizeURL resolves raw against base (which may be empty) and
+// normalizes the result so the same resource always maps to the same
+// string: lowercase scheme and host, no default port, no fragment, no
+// tracking parameters, sorted query and no trailing slash.
+func canonicalizeURL(raw, base string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("empty url")
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if base != "" && !parsed.IsAbs() {
+		baseURL, err := url.Parse(base)
+		if err == nil {
+			parsed = baseURL.ResolveReference(parsed)
+		}
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("unsupported url %q", raw)
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("missing host in url %q", raw)
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	port := parsed.Port()
+	if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		parsed.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		parsed.Host = "[" + host + "]"
+	} else {
+		parsed.Host = host
+	}
+
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	parsed.User = nil
+
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for name := range query {
+			if isTrackingParam(name) {
+				query.Del(name)
+			}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+
+	if len(parsed.Path) > 1 {
+		parsed.Path = strings.TrimRight(parsed.Path, "/")
+		parsed.RawPath = strings.TrimRight(parsed.RawPath, "/")
+	}
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+
+	return parsed.String(), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+)
+
+// xmlDeclarationSniffLen is how far into the body the XML declaration is
+// looked for.
+const xmlDeclarationSniffLen = 1024
+
+var xmlDeclarationEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*?\sencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+// newFeedDecoder returns an XML decoder that reads feeds in legacy
+// encodings. The encoding is picked before parsing: a byte order mark wins,
+// then a charset in the HTTP Content-Type header, then the XML declaration,
+// and UTF-8 otherwise. The body is transcoded to UTF-8 up front, so the
+// decoder never switches encodings on its own.
+func newFeedDecoder(r io.Reader, contentType string) (*xml.Decoder, error) {
+	br := bufio.NewReader(r)
+	label := contentTypeCharset(contentType)
+	if label == "" {
+		label = declaredCharset(br)
+	}
+
+	fallback := encoding.Nop
+	if label != "" && !isUTF8(label) {
+		fallback, _ = charset.Lookup(label)
+		if fallback == nil {
+			return nil, fmt.Errorf("unsupported charset %q", label)
+		}
+	}
+	utf8Body := transform.NewReader(br, unicode.BOMOverride(fallback.NewDecoder()))
+
+	decoder := xml.NewDecoder(transform.NewReader(utf8Body, runes.Remove(runes.Predicate(isForbiddenControl))))
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder, nil
+}
+
+func contentTypeCharset(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params["charset"])
+}
+
+// declaredCharset returns the encoding named in the XML declaration at the
+// start of br, without consuming anything.
+func declaredCharset(br *bufio.Reader) string {
+	// Peek returns what there is along with an error for short bodies.
+	prefix, _ := br.Peek(xmlDeclarationSniffLen)
+	match := xmlDeclarationEncoding.FindSubmatch(prefix)
+	if match == nil {
+		return ""
+	}
+	return string(match[1])
+}
+
+func isUTF8(label string) bool {
+	label = strings.ToLower(label)
+	return label == "utf-8" || label == "utf8"
+}
+
+// isForbiddenControl reports the C0 control characters that XML 1.0
+// forbids but many feeds contain anyway.
+func isForbiddenControl(r rune) bool {
+	return r < 0x20 && r != '\t' && r != '\n' && r != '\r'
+}
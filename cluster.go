@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const (
+	defaultClusterMaxDistance = 6
+	defaultClusterWindow      = 72 * time.Hour
+	minFingerprintWords       = 10
+)
+
+// postClusterer groups near-duplicate posts, such as the same wire story
+// published by several outlets, by comparing SimHash fingerprints.
+type postClusterer struct {
+	maxDistance int32
+	window      time.Duration
+}
+
+func newPostClusterer(maxDistance int, window time.Duration) *postClusterer {
+	return &postClusterer{
+		maxDistance: int32(maxDistance),
+		window:      window,
+	}
+}
+
+// assignCluster moves a newly inserted post, which starts out as its own
+// cluster, into the cluster of the closest post from another feed
+// published within the window around publishedAt.
+func (c *postClusterer) assignCluster(ctx context.Context, dbQ *db.Queries, postID, feedID uuid.UUID, fingerprint sql.NullInt64, publishedAt time.Time) {
+	if !fingerprint.Valid {
+		return
+	}
+	similar, err := dbQ.FindSimilarPost(ctx, db.FindSimilarPostParams{
+		Since:       publishedAt.Add(-c.window),
+		Until:       publishedAt.Add(c.window),
+		FeedID:      feedID,
+		Fingerprint: fingerprint.Int64,
+		MaxDistance: c.maxDistance,
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("error finding similar post:", err)
+		}
+		return
+	}
+	err = dbQ.SetPostCluster(ctx, db.SetPostClusterParams{
+		ID:        postID,
+		ClusterID: similar.ClusterID,
+	})
+	if err != nil {
+		log.Println("error setting post cluster:", err)
+	}
+}
+
+// simhash computes a 64-bit SimHash over the words of text, so texts that
+// share most of their wording differ in only a few bits. Texts too short
+// to tell apart reliably get no fingerprint.
+func simhash(text string) (int64, bool) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) < minFingerprintWords {
+		return 0, false
+	}
+
+	var weights [64]int
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return int64(fingerprint), true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func envInt64(name string, fallback int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid %s env: %v", name, err)
+	}
+	return parsed
+}
+
+func envInt(name string, fallback int) int {
+	return int(envInt64(name, int64(fallback)))
+}
+
+func envList(name string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func envBool(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("invalid %s env: %v", name, err)
+	}
+	return parsed
+}
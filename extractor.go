@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const (
+	defaultFullContentRate = 30
+	fullContentBatchSize   = 20
+	fullContentPollEvery   = time.Minute
+)
+
+// contentExtractor fetches the article page of new posts from feeds that
+// opted into full-content extraction. It runs apart from the scraper and
+// spaces its requests out so it never slows down feed polling.
+type contentExtractor struct {
+	db       *db.Queries
+	fetcher  *feedFetcher
+	interval time.Duration
+}
+
+func newContentExtractor(dbQ *db.Queries, fetcher *feedFetcher, requestsPerMinute int) *contentExtractor {
+	return &contentExtractor{
+		db:       dbQ,
+		fetcher:  fetcher,
+		interval: time.Minute / time.Duration(requestsPerMinute),
+	}
+}
+
+func (e *contentExtractor) start(ctx context.Context) {
+	log.Printf("starting full content extraction every %v", e.interval)
+
+	limiter := time.NewTicker(e.interval)
+	defer limiter.Stop()
+	for {
+		posts, err := e.db.GetPostsPendingFullContent(ctx, fullContentBatchSize)
+		if err != nil {
+			log.Println("error getting posts pending full content:", err)
+		}
+		if len(posts) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(fullContentPollEvery):
+			}
+			continue
+		}
+
+		for _, post := range posts {
+			select {
+			case <-ctx.Done():
+				return
+			case <-limiter.C:
+			}
+			e.extract(ctx, post)
+		}
+	}
+}
+
+// extract stores the post's full content. Failures are recorded as well,
+// with no content, so a broken page isn't fetched over and over. The page
+// is fetched from the link as published: the canonical URL that posts are
+// deduplicated by isn't necessarily one the publisher serves.
+func (e *contentExtractor) extract(ctx context.Context, post db.Post) {
+	var article string
+	doc, err := e.fetcher.fetchArticle(post.OriginalUrl)
+	if err != nil {
+		log.Printf("error fetching article %v: %v", post.OriginalUrl, err)
+	} else if article = extractArticle(doc, post.OriginalUrl); article == "" {
+		log.Printf("no article content found at %v", post.OriginalUrl)
+	}
+
+	err = e.db.UpdatePostFullContent(ctx, db.UpdatePostFullContentParams{
+		ID:          post.ID,
+		FullContent: toNullString(article),
+	})
+	if err != nil {
+		log.Printf("error storing full content of post %v: %v", post.ID, err)
+	}
+}
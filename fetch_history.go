@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const (
+	defaultFetchRetentionDays = 30
+	fetchPruneInterval        = time.Hour
+)
+
+// recordFeedFetch stores one scrape attempt so a feed's fetch history can
+// explain why it isn't updating.
+func (s *scraper) recordFeedFetch(ctx context.Context, feed db.Feed, startedAt time.Time, result scrapeResult) {
+	finishedAt := time.Now().UTC()
+	errText := ""
+	if result.err != nil {
+		errText = result.err.Error()
+	}
+	err := s.db.CreateFeedFetch(ctx, db.CreateFeedFetchParams{
+		ID:            uuid.New(),
+		FeedID:        feed.ID,
+		StartedAt:     startedAt,
+		FinishedAt:    finishedAt,
+		HttpStatus:    sql.NullInt32{Int32: int32(result.stats.statusCode), Valid: result.stats.statusCode != 0},
+		Bytes:         sql.NullInt64{Int64: result.stats.bytes, Valid: result.stats.statusCode != 0},
+		DurationMs:    int32(finishedAt.Sub(startedAt).Milliseconds()),
+		ItemsSeen:     int32(result.itemsSeen),
+		PostsInserted: int32(result.newPosts),
+		PostsUpdated:  int32(result.updatedPosts),
+		Error:         toNullString(errText),
+	})
+	if err != nil {
+		log.Printf("error recording fetch of feed %s: %v", feed.Name, err)
+	}
+}
+
+func (s *scraper) pruneFeedFetches(ctx context.Context) {
+	if s.fetchRetentionDays <= 0 {
+		return
+	}
+	pruned, err := s.db.DeleteFeedFetchesOlderThan(ctx, int32(s.fetchRetentionDays))
+	if err != nil {
+		log.Println("error pruning feed fetch history:", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("pruned %v feed fetches older than %v days", pruned, s.fetchRetentionDays)
+	}
+}
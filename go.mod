@@ -10,3 +10,9 @@ require (
 )
 
 require github.com/lib/pq v1.10.9
+
+require (
+	golang.org/x/crypto v0.55.0
+	golang.org/x/net v0.58.0
+	golang.org/x/text v0.41.0
+)
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+func (apiCfg *apiConfig) handlerAdminGetUsers(w http.ResponseWriter, r *http.Request, admin db.User) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	users, err := apiCfg.DB.GetUsers(r.Context(), db.GetUsersParams{
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get users: %v", err))
+		return
+	}
+	respondWithJson(w, 200, dbUsersToUsers(users))
+}
+
+// handlerAdminUpdateUser disables or re-enables a user, and grants or
+// revokes admin. Admins can't do either to themselves, so there is always
+// one left.
+func (apiCfg *apiConfig) handlerAdminUpdateUser(w http.ResponseWriter, r *http.Request, admin db.User) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing user ID: %v", err))
+		return
+	}
+	type parameters struct {
+		Disabled *bool `json:"disabled"`
+		IsAdmin  *bool `json:"is_admin"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	if params.Disabled == nil && params.IsAdmin == nil {
+		respondWithError(w, 400, "nothing to update: set disabled or is_admin")
+		return
+	}
+	if userID == admin.ID {
+		respondWithError(w, 400, "admins can't disable or demote themselves")
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error updating user: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	user, err := qtx.GetUser(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "user not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get user: %v", err))
+		return
+	}
+	if params.Disabled != nil {
+		user, err = qtx.SetUserDisabled(r.Context(), db.SetUserDisabledParams{
+			Disabled: *params.Disabled,
+			ID:       userID,
+		})
+		if err != nil {
+			respondWithError(w, 400, fmt.Sprintf("couldn't update user: %v", err))
+			return
+		}
+		// Token sessions end for good; API keys work again once the user
+		// is re-enabled.
+		if *params.Disabled {
+			err = qtx.DeleteRefreshTokensForUser(r.Context(), userID)
+			if err != nil {
+				respondWithError(w, 400, fmt.Sprintf("couldn't update user: %v", err))
+				return
+			}
+		}
+	}
+	if params.IsAdmin != nil {
+		user, err = qtx.SetUserAdmin(r.Context(), db.SetUserAdminParams{
+			ID:      userID,
+			IsAdmin: *params.IsAdmin,
+		})
+		if err != nil {
+			respondWithError(w, 400, fmt.Sprintf("couldn't update user: %v", err))
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error updating user: %v", err))
+		return
+	}
+
+	respondWithJson(w, 200, dbUserToUser(user))
+}
+
+// handlerAdminSetQuotas overrides a user's feed and follow limits. A null
+// limit goes back to the default.
+func (apiCfg *apiConfig) handlerAdminSetQuotas(w http.ResponseWriter, r *http.Request, admin db.User) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing user ID: %v", err))
+		return
+	}
+	type parameters struct {
+		MaxFeeds   *int32 `json:"max_feeds"`
+		MaxFollows *int32 `json:"max_follows"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	maxFeeds, maxFollows := sql.NullInt32{}, sql.NullInt32{}
+	if params.MaxFeeds != nil {
+		maxFeeds = sql.NullInt32{Int32: *params.MaxFeeds, Valid: true}
+	}
+	if params.MaxFollows != nil {
+		maxFollows = sql.NullInt32{Int32: *params.MaxFollows, Valid: true}
+	}
+	if maxFeeds.Int32 < 0 || maxFollows.Int32 < 0 {
+		respondWithError(w, 400, "quotas can't be negative")
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error setting quotas: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	err = qtx.SetUserQuotas(r.Context(), db.SetUserQuotasParams{
+		ID:         userID,
+		MaxFeeds:   maxFeeds,
+		MaxFollows: maxFollows,
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't set quotas: %v", err))
+		return
+	}
+	usage, err := qtx.GetUserQuotaUsage(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "user not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get quotas: %v", err))
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error setting quotas: %v", err))
+		return
+	}
+
+	respondWithJson(w, 200, apiCfg.Quotas.userQuotas(usage))
+}
+
+// handlerAdminDeleteUser deletes a user along with their keys and follows.
+// Feeds they created that others follow are handed over, like when users
+// delete their own account.
+func (apiCfg *apiConfig) handlerAdminDeleteUser(w http.ResponseWriter, r *http.Request, admin db.User) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing user ID: %v", err))
+		return
+	}
+	if userID == admin.ID {
+		respondWithError(w, 400, "admins can't delete themselves here")
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error deleting user: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	deleted, err := deleteUser(r.Context(), apiCfg.DB.WithTx(tx), userID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't delete user: %v", err))
+		return
+	}
+	if !deleted {
+		respondWithError(w, 404, "user not found")
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error deleting user: %v", err))
+		return
+	}
+	respondWithJson(w, 200, struct{}{})
+}
+
+func (apiCfg *apiConfig) handlerAdminGetFeedHealth(w http.ResponseWriter, r *http.Request, admin db.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing feed ID: %v", err))
+		return
+	}
+	feed, err := apiCfg.DB.GetFeed(r.Context(), feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "feed not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get feed: %v", err))
+		return
+	}
+
+	health := FeedHealth{Feed: dbFeedToFeed(feed)}
+	health.Followers, err = apiCfg.DB.CountFeedFollowers(r.Context(), feedID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't count followers: %v", err))
+		return
+	}
+	stats, err := apiCfg.DB.GetFeedFetchStats(r.Context(), feedID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get fetch stats: %v", err))
+		return
+	}
+	health.Fetches = stats.Fetches
+	health.Failures = stats.Failures
+	health.ConsecutiveFailures = stats.ConsecutiveFailures
+
+	lastFetches, err := apiCfg.DB.GetFeedFetches(r.Context(), db.GetFeedFetchesParams{
+		FeedID: feedID,
+		Limit:  1,
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get feed fetches: %v", err))
+		return
+	}
+	if len(lastFetches) > 0 {
+		lastFetch := dbFeedFetchToFeedFetch(lastFetches[0])
+		health.LastFetch = &lastFetch
+	}
+	lastSuccess, err := apiCfg.DB.GetLastSuccessfulFeedFetch(r.Context(), feedID)
+	if err == nil {
+		fetch := dbFeedFetchToFeedFetch(lastSuccess)
+		health.LastSuccess = &fetch
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get feed fetches: %v", err))
+		return
+	}
+
+	subscription, err := apiCfg.DB.GetWebSubSubscription(r.Context(), feedID)
+	if err == nil {
+		health.WebSubHub = &subscription.HubUrl
+		health.WebSubLeaseExpires = nullTimeToPtr(subscription.LeaseExpiresAt)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get subscription: %v", err))
+		return
+	}
+
+	respondWithJson(w, 200, health)
+}
+
+// handlerAdminDeleteFeed deletes a feed with its posts, follows and fetch
+// history.
+func (apiCfg *apiConfig) handlerAdminDeleteFeed(w http.ResponseWriter, r *http.Request, admin db.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing feed ID: %v", err))
+		return
+	}
+	deleted, err := apiCfg.DB.DeleteFeed(r.Context(), feedID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't delete feed: %v", err))
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "feed not found")
+		return
+	}
+	respondWithJson(w, 200, struct{}{})
+}
+
+func (apiCfg *apiConfig) handlerAdminGetStats(w http.ResponseWriter, r *http.Request, admin db.User) {
+	dbStats, err := apiCfg.DB.GetSystemStats(r.Context())
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get stats: %v", err))
+		return
+	}
+	stats := dbSystemStatsToSystemStats(dbStats)
+	stats.RefreshQueueDepth = len(apiCfg.Scraper.refreshes)
+	stats.RefreshQueueSize = cap(apiCfg.Scraper.refreshes)
+	respondWithJson(w, 200, stats)
+}
+
+// promoteAdmins makes the users with the given ids admins, so the first
+// admin can be set up without touching the database.
+func promoteAdmins(ctx context.Context, dbQ *db.Queries, ids []string) {
+	userIDs := make([]uuid.UUID, len(ids))
+	for i, id := range ids {
+		userID, err := uuid.Parse(strings.TrimSpace(id))
+		if err != nil {
+			log.Fatalf("invalid ADMIN_USER_IDS entry %q: %v", id, err)
+		}
+		userIDs[i] = userID
+	}
+	promoted, err := dbQ.PromoteUsersToAdmin(ctx, userIDs)
+	if err != nil {
+		log.Fatal("couldn't promote admins: ", err)
+	}
+	if promoted > 0 {
+		log.Printf("promoted %d users to admin", promoted)
+	}
+}
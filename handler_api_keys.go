@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/viniciuspra/rssagg/internal/auth"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+// createApiKey generates a key for the user and stores its hash. The
+// plaintext key is returned so it can be shown once; it can't be
+// recovered later. Keys of the user whose rotation grace period is over
+// are deleted along the way.
+func createApiKey(ctx context.Context, dbQ *db.Queries, userID uuid.UUID, name string, scopes []string) (db.ApiKey, string, error) {
+	plaintext, err := auth.GenerateApiKey()
+	if err != nil {
+		return db.ApiKey{}, "", err
+	}
+	err = dbQ.DeleteExpiredApiKeys(ctx, userID)
+	if err != nil {
+		return db.ApiKey{}, "", err
+	}
+	apiKey, err := dbQ.CreateApiKey(ctx, db.CreateApiKeyParams{
+		ID: uuid.New(),
+		UserID: userID,
+		Name: name,
+		KeyHash: auth.HashApiKey(plaintext),
+		Prefix: auth.ApiKeyPrefix(plaintext),
+		Scopes: scopes,
+	})
+	if err != nil {
+		return db.ApiKey{}, "", err
+	}
+	return apiKey, plaintext, nil
+}
+
+// handlerCreateApiKey creates a key with the scopes asked for, or those of
+// the request's credentials. Credentials can only grant scopes they hold,
+// so a narrowly scoped key can't be traded for a more powerful one.
+func (apiCfg *apiConfig) handlerCreateApiKey(w http.ResponseWriter, r *http.Request, id identity) {
+	type parameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		respondWithError(w, 400, "missing API key name")
+		return
+	}
+	scopes := slices.Clone(id.scopes)
+	if params.Scopes != nil {
+		scopes, err = parseScopes(params.Scopes)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		for _, scope := range scopes {
+			if !auth.HasScope(id.scopes, scope) {
+				respondWithError(w, 403, fmt.Sprintf("credentials can't grant the %v scope they don't have", scope))
+				return
+			}
+		}
+	}
+
+	dbApiKey, plaintext, err := createApiKey(r.Context(), apiCfg.DB, id.user.ID, name, scopes)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error creating API key: %v", err))
+		return
+	}
+
+	apiKey := dbApiKeyToApiKey(dbApiKey)
+	apiKey.Key = plaintext
+	respondWithJson(w, 201, apiKey)
+}
+
+// parseScopes checks scopes asked for in a request and drops duplicates.
+func parseScopes(requested []string) ([]string, error) {
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !auth.ValidScope(scope) {
+			return nil, fmt.Errorf("unknown scope: %v", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is needed")
+	}
+	return scopes, nil
+}
+
+func (apiCfg *apiConfig) handlerGetApiKeys(w http.ResponseWriter, r *http.Request, user db.User) {
+	apiKeys, err := apiCfg.DB.GetApiKeysForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get API keys: %v", err))
+		return
+	}
+
+	respondWithJson(w, 200, dbApiKeysToApiKeys(apiKeys))
+}
+
+func (apiCfg *apiConfig) handlerDeleteApiKey(w http.ResponseWriter, r *http.Request, user db.User) {
+	apiKeyID, err := uuid.Parse(chi.URLParam(r, "apiKeyID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing API key ID: %v", err))
+		return
+	}
+
+	deleted, err := apiCfg.DB.DeleteApiKey(r.Context(), db.DeleteApiKeyParams{
+		ID: apiKeyID,
+		UserID: user.ID,
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't revoke API key: %v", err))
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "API key not found")
+		return
+	}
+
+	respondWithJson(w, 200, struct{}{})
+}
+
+const maxApiKeyGracePeriod = 7 * 24 * time.Hour
+
+// handlerRotateApiKey replaces a key with a new one of the same name. With
+// a grace period the old key keeps working until it ends, so clients can be
+// switched over without downtime; otherwise it is revoked right away. A key
+// already in its grace period can't be rotated again, since that would
+// leave two replacements. The request's credentials must hold every scope
+// of the key, as they would to create it.
+func (apiCfg *apiConfig) handlerRotateApiKey(w http.ResponseWriter, r *http.Request, id identity) {
+	user := id.user
+	apiKeyID, err := uuid.Parse(chi.URLParam(r, "apiKeyID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing API key ID: %v", err))
+		return
+	}
+
+	type parameters struct {
+		GracePeriodSeconds int `json:"grace_period_seconds"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	// An empty body is fine, whether or not its length was sent.
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	gracePeriod := time.Duration(params.GracePeriodSeconds) * time.Second
+	if gracePeriod < 0 || gracePeriod > maxApiKeyGracePeriod {
+		respondWithError(w, 400, fmt.Sprintf("grace_period_seconds must be between 0 and %v", int(maxApiKeyGracePeriod.Seconds())))
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error rotating API key: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	oldApiKey, err := qtx.GetApiKey(r.Context(), db.GetApiKeyParams{
+		ID: apiKeyID,
+		UserID: user.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "API key not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get API key: %v", err))
+		return
+	}
+	if oldApiKey.ExpiresAt.Valid {
+		respondWithError(w, 409, "API key has already been rotated")
+		return
+	}
+	for _, scope := range oldApiKey.Scopes {
+		if !auth.HasScope(id.scopes, scope) {
+			respondWithError(w, 403, fmt.Sprintf("credentials can't grant the %v scope they don't have", scope))
+			return
+		}
+	}
+
+	dbApiKey, plaintext, err := createApiKey(r.Context(), qtx, user.ID, oldApiKey.Name, oldApiKey.Scopes)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error creating API key: %v", err))
+		return
+	}
+	if gracePeriod > 0 {
+		err = qtx.ExpireApiKey(r.Context(), db.ExpireApiKeyParams{
+			GraceSeconds: int32(gracePeriod / time.Second),
+			ID: oldApiKey.ID,
+		})
+	} else {
+		_, err = qtx.DeleteApiKey(r.Context(), db.DeleteApiKeyParams{
+			ID: oldApiKey.ID,
+			UserID: user.ID,
+		})
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't retire old API key: %v", err))
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error rotating API key: %v", err))
+		return
+	}
+
+	apiKey := dbApiKeyToApiKey(dbApiKey)
+	apiKey.Key = plaintext
+	respondWithJson(w, 201, apiKey)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/viniciuspra/rssagg/internal/auth"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const passwordResetTTL = time.Hour
+
+// handlerCreateToken exchanges an API key, or a refresh token from an
+// earlier exchange, for a new access token and refresh token. Refresh
+// tokens can be used once.
+func (apiCfg *apiConfig) handlerCreateToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		GrantType    string `json:"grant_type"`
+		ApiKey       string `json:"api_key"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+
+	switch params.GrantType {
+	case "api_key":
+		if params.ApiKey == "" {
+			respondWithError(w, 400, "missing api_key")
+			return
+		}
+		row, err := apiCfg.DB.GetUserByApiKey(r.Context(), auth.HashApiKey(params.ApiKey))
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 401, "invalid API key")
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("couldn't get user: %v", err))
+			return
+		}
+		if row.User.DisabledAt.Valid {
+			respondWithError(w, 403, "account is disabled")
+			return
+		}
+		token, err := apiCfg.Tokens.issue(r.Context(), apiCfg.DB, row.User.ID, uuid.NullUUID{UUID: row.ApiKeyID, Valid: true}, row.Scopes)
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("error issuing token: %v", err))
+			return
+		}
+		respondWithJson(w, 200, token)
+
+	case "refresh_token":
+		if params.RefreshToken == "" {
+			respondWithError(w, 400, "missing refresh_token")
+			return
+		}
+		tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("error issuing token: %v", err))
+			return
+		}
+		defer tx.Rollback()
+		qtx := apiCfg.DB.WithTx(tx)
+
+		refreshToken, err := qtx.ConsumeRefreshToken(r.Context(), auth.HashApiKey(params.RefreshToken))
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 401, "invalid or expired refresh token")
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("couldn't get refresh token: %v", err))
+			return
+		}
+		token, err := apiCfg.Tokens.issue(r.Context(), qtx, refreshToken.UserID, refreshToken.ApiKeyID, refreshToken.Scopes)
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("error issuing token: %v", err))
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("error issuing token: %v", err))
+			return
+		}
+		respondWithJson(w, 200, token)
+
+	default:
+		respondWithError(w, 400, fmt.Sprintf("unsupported grant_type: %v", params.GrantType))
+	}
+}
+
+// handlerRevokeToken ends the session of a refresh token. Access tokens
+// already issued from it keep working until they expire.
+func (apiCfg *apiConfig) handlerRevokeToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	if params.RefreshToken == "" {
+		respondWithError(w, 400, "missing refresh_token")
+		return
+	}
+
+	// Unknown tokens aren't an error: either way the token no longer works.
+	_, err = apiCfg.DB.DeleteRefreshToken(r.Context(), auth.HashApiKey(params.RefreshToken))
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("couldn't revoke refresh token: %v", err))
+		return
+	}
+	respondWithJson(w, 200, struct{}{})
+}
+
+// handlerLogin checks an email and password. With api_key_name it creates
+// a new API key, which is how a user who lost their keys gets back in;
+// otherwise it starts a token session. Either gets the default scopes
+// unless others are asked for: a password is the one credential that can
+// ask for admin, for users who are admins.
+func (apiCfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email      string   `json:"email"`
+		Password   string   `json:"password"`
+		ApiKeyName string   `json:"api_key_name"`
+		Scopes     []string `json:"scopes"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	apiKeyName := strings.TrimSpace(params.ApiKeyName)
+	if apiKeyName == "" && apiCfg.Tokens == nil {
+		respondWithError(w, 400, "access tokens are disabled: pass api_key_name to get an API key instead")
+		return
+	}
+	scopes := auth.DefaultScopes
+	if params.Scopes != nil {
+		scopes, err = parseScopes(params.Scopes)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+
+	email := strings.ToLower(strings.TrimSpace(params.Email))
+	// Password guesses against one account are throttled wherever they
+	// come from.
+	if !apiCfg.RateLimiter.allowEmail(w, r, "login", email) {
+		return
+	}
+
+	// A missing user still goes through CheckPassword, with an empty hash,
+	// so the response time doesn't tell which emails have accounts.
+	user, err := apiCfg.DB.GetUserByEmail(r.Context(), sql.NullString{
+		String: email,
+		Valid:  true,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 500, fmt.Sprintf("couldn't get user: %v", err))
+		return
+	}
+	err = auth.CheckPassword(user.PasswordHash.String, params.Password)
+	if errors.Is(err, auth.ErrWrongPassword) {
+		respondWithError(w, 401, "invalid email or password")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error checking password: %v", err))
+		return
+	}
+	if user.DisabledAt.Valid {
+		respondWithError(w, 403, "account is disabled")
+		return
+	}
+	if slices.Contains(scopes, auth.ScopeAdmin) && !user.IsAdmin {
+		respondWithError(w, 403, "only admins can have the admin scope")
+		return
+	}
+
+	if apiKeyName != "" {
+		dbApiKey, plaintext, err := createApiKey(r.Context(), apiCfg.DB, user.ID, apiKeyName, scopes)
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("error creating API key: %v", err))
+			return
+		}
+		apiKey := dbApiKeyToApiKey(dbApiKey)
+		apiKey.Key = plaintext
+		respondWithJson(w, 201, apiKey)
+		return
+	}
+
+	token, err := apiCfg.Tokens.issue(r.Context(), apiCfg.DB, user.ID, uuid.NullUUID{}, scopes)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error issuing token: %v", err))
+		return
+	}
+	respondWithJson(w, 200, token)
+}
+
+// handlerRequestPasswordReset mails a single-use reset link to the user
+// with the given email. It responds the same whether or not there is one,
+// before looking the email up, so that neither the response nor the time
+// it takes gives away which emails have accounts.
+func (apiCfg *apiConfig) handlerRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	email, err := normalizeEmail(params.Email)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	// Throttled so that nobody's inbox can be flooded with reset mails.
+	if !apiCfg.RateLimiter.allowEmail(w, r, "password-reset", email) {
+		return
+	}
+
+	go apiCfg.sendPasswordReset(email)
+	respondWithJson(w, 202, struct{}{})
+}
+
+// sendPasswordReset creates a reset token for the user with the given
+// email, if there is one, and mails it to them.
+func (apiCfg *apiConfig) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	user, err := apiCfg.DB.GetUserByEmail(ctx, sql.NullString{String: email, Valid: true})
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("couldn't get user for password reset: %v", err)
+		return
+	}
+
+	token, err := auth.GeneratePasswordResetToken()
+	if err != nil {
+		log.Printf("error creating reset token for user %v: %v", user.ID, err)
+		return
+	}
+	_, err = apiCfg.DB.CreatePasswordReset(ctx, db.CreatePasswordResetParams{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		TokenHash:  auth.HashApiKey(token),
+		TtlSeconds: int32(passwordResetTTL / time.Second),
+	})
+	if err != nil {
+		log.Printf("error creating reset token for user %v: %v", user.ID, err)
+		return
+	}
+
+	err = apiCfg.Mailer.send(ctx, email, "Reset your rssagg password", apiCfg.passwordResetMail(token))
+	if err != nil {
+		log.Printf("couldn't send password reset to user %v: %v", user.ID, err)
+	}
+}
+
+func (apiCfg *apiConfig) passwordResetMail(token string) string {
+	var sb strings.Builder
+	sb.WriteString("Someone asked to reset the password of your rssagg account.\n\n")
+	if apiCfg.PasswordResetURL != "" {
+		link, err := url.Parse(apiCfg.PasswordResetURL)
+		if err == nil {
+			query := link.Query()
+			query.Set("token", token)
+			link.RawQuery = query.Encode()
+			fmt.Fprintf(&sb, "To choose a new password, open:\n\n%v\n\n", link)
+		}
+	}
+	fmt.Fprintf(&sb, "Your reset token is:\n\n%v\n\n", token)
+	fmt.Fprintf(&sb, "It works once, for the next %d minutes. If you didn't ask for this, you can ignore this email.\n", int(passwordResetTTL/time.Minute))
+	return sb.String()
+}
+
+// handlerResetPassword sets a new password with a token from a reset email,
+// ending the user's sessions.
+func (apiCfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	if err := auth.ValidatePassword(params.NewPassword); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	hash, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error hashing password: %v", err))
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error resetting password: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	reset, err := qtx.ConsumePasswordReset(r.Context(), auth.HashApiKey(params.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("couldn't get reset token: %v", err))
+		return
+	}
+	err = setPassword(r.Context(), qtx, reset.UserID, hash)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error resetting password: %v", err))
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error resetting password: %v", err))
+		return
+	}
+	respondWithJson(w, 200, struct{}{})
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/google/uuid"
 	"github.com/viniciuspra/rssagg/internal/db"
 )
@@ -23,16 +30,45 @@ func (apiCfg *apiConfig) handlerCreateFeed(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	feed, err := apiCfg.DB.CreateFeed(r.Context(), db.CreateFeedParams{
+	feedURL, err := canonicalizeURL(params.Url, "")
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("invalid feed URL: %v", err))
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error creating feed: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	usage, err := qtx.GetUserQuotaUsage(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get quotas: %v", err))
+		return
+	}
+	if respondIfQuotaReached(w, "feeds", apiCfg.Quotas.userQuotas(usage).Feeds) {
+		return
+	}
+
+	feed, err := qtx.CreateFeed(r.Context(), db.CreateFeedParams{
 		ID: uuid.New(),
 		Name: params.Name,
-		Url: params.Url,
+		Url: feedURL,
 		UserID: user.ID,
+		OriginalUrl: strings.TrimSpace(params.Url),
 	})
 	if err != nil {
 		respondWithError(w, 400, fmt.Sprintf("error creating feed: %v", err))
 		return
 	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error creating feed: %v", err))
+		return
+	}
 
 	respondWithJson(w, 201, dbFeedToFeed(feed))
 }
@@ -46,3 +82,189 @@ func (apiCfg *apiConfig) handerGetFeeds(w http.ResponseWriter, r *http.Request)
 
 	respondWithJson(w, 200, dbFeedsToFeeds(feeds))
 }
+
+func (apiCfg *apiConfig) handlerUpdateFeed(w http.ResponseWriter, r *http.Request, user db.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing feed ID: %v", err))
+		return
+	}
+
+	type parameters struct {
+		ExtractFullContent *bool `json:"extract_full_content"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	if params.ExtractFullContent == nil {
+		respondWithError(w, 400, "missing extract_full_content")
+		return
+	}
+
+	feed, err := apiCfg.DB.SetFeedFullContentExtraction(r.Context(), db.SetFeedFullContentExtractionParams{
+		Enabled: *params.ExtractFullContent,
+		ID: feedID,
+		UserID: user.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "feed not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error updating feed: %v", err))
+		return
+	}
+
+	respondWithJson(w, 200, dbFeedToFeed(feed))
+}
+
+const maxRefreshWait = time.Minute
+
+// handlerRefreshFeed queues a feed the user created or follows for an
+// immediate scrape. With ?wait=N it waits up to N seconds for the scrape
+// and reports its outcome; otherwise, or when the wait runs out, it answers
+// 202 with status "queued".
+func (apiCfg *apiConfig) handlerRefreshFeed(w http.ResponseWriter, r *http.Request, user db.User) {
+	apiCfg.refreshFeed(w, r, func(ctx context.Context, feedID uuid.UUID) (db.Feed, error) {
+		return apiCfg.DB.GetFeedForUser(ctx, db.GetFeedForUserParams{
+			ID: feedID,
+			UserID: user.ID,
+		})
+	})
+}
+
+// handlerAdminRefreshFeed is handlerRefreshFeed for any feed.
+func (apiCfg *apiConfig) handlerAdminRefreshFeed(w http.ResponseWriter, r *http.Request, admin db.User) {
+	apiCfg.refreshFeed(w, r, apiCfg.DB.GetFeed)
+}
+
+func (apiCfg *apiConfig) refreshFeed(w http.ResponseWriter, r *http.Request, getFeed func(context.Context, uuid.UUID) (db.Feed, error)) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing feed ID: %v", err))
+		return
+	}
+
+	wait := time.Duration(0)
+	if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+		seconds, err := strconv.Atoi(waitParam)
+		if err != nil || seconds < 0 {
+			respondWithError(w, 400, fmt.Sprintf("invalid wait value: %v", waitParam))
+			return
+		}
+		wait = min(time.Duration(seconds)*time.Second, maxRefreshWait)
+	}
+
+	feed, err := getFeed(r.Context(), feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "feed not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get feed: %v", err))
+		return
+	}
+
+	result, ok := apiCfg.Scraper.requestRefresh(feed)
+	if !ok {
+		respondWithError(w, 503, "refresh queue is full, try again later")
+		return
+	}
+
+	queued := FeedRefresh{FeedID: feed.ID, Status: "queued"}
+	if wait == 0 {
+		respondWithJson(w, 202, queued)
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case res := <-result:
+		respondWithJson(w, 200, scrapeResultToFeedRefresh(feed.ID, res))
+	case <-timer.C:
+		respondWithJson(w, 202, queued)
+	case <-r.Context().Done():
+		// The client went away, so there is nobody to answer. The refresh
+		// stays queued and still runs.
+		return
+	}
+}
+
+const (
+	defaultPageSize      = 20
+	defaultPostsPageSize = 10
+	maxPageSize          = 100
+)
+
+// parsePagination reads ?limit= and ?offset= with defaults and bounds.
+func parsePagination(r *http.Request) (limit, offset int32, err error) {
+	return parsePaginationWithDefault(r, defaultPageSize)
+}
+
+// parsePaginationWithDefault is parsePagination with pageSize as the limit
+// when none is given.
+func parsePaginationWithDefault(r *http.Request, pageSize int32) (limit, offset int32, err error) {
+	limit = pageSize
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 {
+			return 0, 0, fmt.Errorf("invalid limit value: %v", limitParam)
+		}
+		limit = int32(min(parsed, maxPageSize))
+	}
+	if offsetParam := r.URL.Query().Get("offset"); offsetParam != "" {
+		// Parsed as 32 bits, so that huge offsets are rejected rather than
+		// wrapping around.
+		parsed, err := strconv.ParseInt(offsetParam, 10, 32)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("invalid offset value: %v", offsetParam)
+		}
+		offset = int32(parsed)
+	}
+	return limit, offset, nil
+}
+
+// handlerGetFeedFetches lists the fetch history of a feed the user created
+// or follows.
+func (apiCfg *apiConfig) handlerGetFeedFetches(w http.ResponseWriter, r *http.Request, user db.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing feed ID: %v", err))
+		return
+	}
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	_, err = apiCfg.DB.GetFeedForUser(r.Context(), db.GetFeedForUserParams{
+		ID: feedID,
+		UserID: user.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "feed not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get feed: %v", err))
+		return
+	}
+
+	feedFetches, err := apiCfg.DB.GetFeedFetches(r.Context(), db.GetFeedFetchesParams{
+		FeedID: feedID,
+		Limit: limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get feed fetches: %v", err))
+		return
+	}
+
+	respondWithJson(w, 200, dbFeedFetchesToFeedFetches(feedFetches))
+}
@@ -23,7 +23,24 @@ func (apiCfg *apiConfig) handlerCreateFeedFollow(w http.ResponseWriter, r *http.
 		return
 	}
 
-	feedFollow, err := apiCfg.DB.CreateFeedFollow(r.Context(), db.CreateFeedFollowParams{
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error creating feed follow: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	usage, err := qtx.GetUserQuotaUsage(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get quotas: %v", err))
+		return
+	}
+	if respondIfQuotaReached(w, "follows", apiCfg.Quotas.userQuotas(usage).Follows) {
+		return
+	}
+
+	feedFollow, err := qtx.CreateFeedFollow(r.Context(), db.CreateFeedFollowParams{
 		ID: uuid.New(),
 		UserID: user.ID,
 		FeedID: params.FeedID,
@@ -32,6 +49,11 @@ func (apiCfg *apiConfig) handlerCreateFeedFollow(w http.ResponseWriter, r *http.
 		respondWithError(w, 400, fmt.Sprintf("error creating feed follow: %v", err))
 		return
 	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error creating feed follow: %v", err))
+		return
+	}
 
 	respondWithJson(w, 201, dbFeedFollowToFeedFollow(feedFollow))
 }
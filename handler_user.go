@@ -1,11 +1,22 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/mail"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/viniciuspra/rssagg/internal/auth"
 	"github.com/viniciuspra/rssagg/internal/db"
 )
 
@@ -13,6 +24,8 @@ import (
 func (apiCfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Name string `json:"name"`
+		Email string `json:"email"`
+		Password string `json:"password"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -22,31 +35,595 @@ func (apiCfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	user, err := apiCfg.DB.CreateUser(r.Context(), db.CreateUserParams{
+	email := sql.NullString{}
+	if params.Email != "" {
+		normalized, err := normalizeEmail(params.Email)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		email = sql.NullString{String: normalized, Valid: true}
+	}
+	passwordHash := sql.NullString{}
+	if params.Password != "" {
+		if !email.Valid {
+			respondWithError(w, 400, "a password needs an email to log in with")
+			return
+		}
+		if err := auth.ValidatePassword(params.Password); err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		hash, err := auth.HashPassword(params.Password)
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("error hashing password: %v", err))
+			return
+		}
+		passwordHash = sql.NullString{String: hash, Valid: true}
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error creating user: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	user, err := qtx.CreateUser(r.Context(), db.CreateUserParams{
 		ID: uuid.New(),
 		Name: params.Name,
+		Email: email,
+		PasswordHash: passwordHash,
 	})
+	if isUniqueViolation(err) {
+		respondWithError(w, 409, "email is already in use")
+		return
+	}
 	if err != nil {
 		respondWithError(w, 400, fmt.Sprintf("error creating user: %v", err))
 		return
 	}
+	_, apiKey, err := createApiKey(r.Context(), qtx, user.ID, "default", auth.DefaultScopes)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error creating API key: %v", err))
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error creating user: %v", err))
+		return
+	}
 
-	respondWithJson(w, 201, dbUserToUser(user))
+	apiUser := dbUserToUser(user)
+	apiUser.ApiKey = apiKey
+	respondWithJson(w, 201, apiUser)
 }
 
 func (apiCfg *apiConfig) handlerGetUser(w http.ResponseWriter, r *http.Request, user db.User) {
 	respondWithJson(w, 200, dbUserToUser(user))
 }
 
-func (apiCfg *apiConfig) handlerPostsForUser(w http.ResponseWriter, r *http.Request, user db.User) {
-	posts, err := apiCfg.DB.GetPostsForUser(r.Context(), db.GetPostsForUserParams{
-		UserID: user.ID,
-		Limit: 10,
+func (apiCfg *apiConfig) handlerGetQuotas(w http.ResponseWriter, r *http.Request, user db.User) {
+	usage, err := apiCfg.DB.GetUserQuotaUsage(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get quotas: %v", err))
+		return
+	}
+	respondWithJson(w, 200, apiCfg.Quotas.userQuotas(usage))
+}
+
+// handlerUpdateUser changes the user's name and their defaults for GET
+// /posts. Only the fields given are changed; display_preferences is merged
+// into the stored preferences the same way.
+func (apiCfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request, user db.User) {
+	type parameters struct {
+		Name *string `json:"name"`
+		Timezone *string `json:"timezone"`
+		// PageSize 0 goes back to the server default.
+		PageSize *int32 `json:"page_size"`
+		PostSort *string `json:"post_sort"`
+		DisplayPreferences json.RawMessage `json:"display_preferences"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+
+	update := db.UpdateUserProfileParams{
+		ID: user.ID,
+		Name: user.Name,
+		Timezone: user.Timezone,
+		PageSize: user.PageSize,
+		PostSort: user.PostSort,
+		DisplayPreferences: user.DisplayPreferences,
+	}
+	if params.Name != nil {
+		update.Name = strings.TrimSpace(*params.Name)
+		if update.Name == "" {
+			respondWithError(w, 400, "name can't be empty")
+			return
+		}
+	}
+	if params.Timezone != nil {
+		if _, err := loadTimezone(*params.Timezone); err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		update.Timezone = *params.Timezone
+	}
+	if params.PageSize != nil {
+		if *params.PageSize < 0 || *params.PageSize > maxPageSize {
+			respondWithError(w, 400, fmt.Sprintf("page_size must be between 1 and %v, or 0 for the default", maxPageSize))
+			return
+		}
+		update.PageSize = sql.NullInt32{Int32: *params.PageSize, Valid: *params.PageSize != 0}
+	}
+	if params.PostSort != nil {
+		if err := validatePostSort(*params.PostSort); err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		update.PostSort = *params.PostSort
+	}
+	if params.DisplayPreferences != nil {
+		update.DisplayPreferences, err = mergeDisplayPreferences(user.DisplayPreferences, params.DisplayPreferences)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+
+	user, err = apiCfg.DB.UpdateUserProfile(r.Context(), update)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't update user: %v", err))
+		return
+	}
+	respondWithJson(w, 200, dbUserToUser(user))
+}
+
+// handlerDeleteUser deletes the user's account along with their keys and
+// follows. Feeds they created that others follow are handed over instead
+// of deleted. If the user has a password, it must be given.
+func (apiCfg *apiConfig) handlerDeleteUser(w http.ResponseWriter, r *http.Request, user db.User) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&params)
+	// An empty body is fine, whether or not its length was sent.
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	if user.PasswordHash.Valid && !apiCfg.checkPassword(w, user, params.Password) {
+		return
+	}
+	if user.IsAdmin {
+		respondWithError(w, 400, "admins must be demoted by another admin before deleting their account")
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error deleting user: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	_, err = deleteUser(r.Context(), apiCfg.DB.WithTx(tx), user.ID)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error deleting user: %v", err))
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error deleting user: %v", err))
+		return
+	}
+	respondWithJson(w, 200, struct{}{})
+}
+
+// deleteUser hands the feeds the user created over to their longest-standing
+// other follower, then deletes the user; everything else of theirs goes
+// with them. dbQ should be bound to a transaction.
+func deleteUser(ctx context.Context, dbQ *db.Queries, userID uuid.UUID) (deleted bool, err error) {
+	_, err = dbQ.ReassignFeedsOfUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := dbQ.DeleteUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// handlerExportUser returns a zip of everything stored about the user:
+// their profile, the feeds they follow (as OPML and JSON), the feeds they
+// created and their API keys, without the keys themselves.
+func (apiCfg *apiConfig) handlerExportUser(w http.ResponseWriter, r *http.Request, user db.User) {
+	followed, err := apiCfg.DB.GetFeedsFollowedByUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get followed feeds: %v", err))
+		return
+	}
+	follows, err := apiCfg.DB.GetFeedFollows(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get feed follows: %v", err))
+		return
+	}
+	created, err := apiCfg.DB.GetFeedsCreatedByUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get created feeds: %v", err))
+		return
+	}
+	apiKeys, err := apiCfg.DB.GetApiKeysForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get API keys: %v", err))
+		return
+	}
+	followsOPML, err := feedsToOPML(fmt.Sprintf("Feeds followed by %v", user.Name), followed)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error exporting follows: %v", err))
+		return
+	}
+
+	// The zip is built in memory so that errors can still get a JSON
+	// response.
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", dbUserToUser(user)},
+		{"follows.json", dbFeedFollowsToFeedFollows(follows)},
+		{"followed_feeds.json", dbFeedsToFeeds(followed)},
+		{"created_feeds.json", dbFeedsToFeeds(created)},
+		{"api_keys.json", dbApiKeysToApiKeys(apiKeys)},
+		{"follows.opml", followsOPML},
+	}
+	for _, file := range files {
+		content, ok := file.content.([]byte)
+		if !ok {
+			content, err = json.MarshalIndent(file.content, "", "  ")
+			if err != nil {
+				respondWithError(w, 500, fmt.Sprintf("error exporting %v: %v", file.name, err))
+				return
+			}
+		}
+		f, err := archive.Create(file.name)
+		if err == nil {
+			_, err = f.Write(content)
+		}
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("error exporting %v: %v", file.name, err))
+			return
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error exporting user: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="rssagg-export-%v.zip"`, time.Now().UTC().Format("2006-01-02")))
+	w.WriteHeader(200)
+	w.Write(buf.Bytes())
+}
+
+// handlerSetEmail sets the email the user logs in with and gets password
+// reset links at. If the user has a password, it must be given.
+func (apiCfg *apiConfig) handlerSetEmail(w http.ResponseWriter, r *http.Request, user db.User) {
+	type parameters struct {
+		Email string `json:"email"`
+		Password string `json:"password"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	email, err := normalizeEmail(params.Email)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if user.PasswordHash.Valid && !apiCfg.checkPassword(w, user, params.Password) {
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error setting email: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	user, err = qtx.SetUserEmail(r.Context(), db.SetUserEmailParams{
+		ID: user.ID,
+		Email: sql.NullString{String: email, Valid: true},
 	})
+	if isUniqueViolation(err) {
+		respondWithError(w, 409, "email is already in use")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error setting email: %v", err))
+		return
+	}
+	// Reset links already sent went to the old address.
+	err = qtx.DeletePasswordResetsForUser(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error setting email: %v", err))
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error setting email: %v", err))
+		return
+	}
+
+	respondWithJson(w, 200, dbUserToUser(user))
+}
+
+// handlerChangePassword sets a new password, given the current one if the
+// user has one, and ends every session started with a refresh token.
+// API keys keep working.
+func (apiCfg *apiConfig) handlerChangePassword(w http.ResponseWriter, r *http.Request, user db.User) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword string `json:"new_password"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing JSON: %v", err))
+		return
+	}
+	if !user.Email.Valid {
+		respondWithError(w, 400, "set an email before setting a password")
+		return
+	}
+	if user.PasswordHash.Valid && !apiCfg.checkPassword(w, user, params.CurrentPassword) {
+		return
+	}
+	if err := auth.ValidatePassword(params.NewPassword); err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	hash, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error hashing password: %v", err))
+		return
+	}
+
+	tx, err := apiCfg.Conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error changing password: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	err = setPassword(r.Context(), apiCfg.DB.WithTx(tx), user.ID, hash)
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error changing password: %v", err))
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error changing password: %v", err))
+		return
+	}
+	respondWithJson(w, 200, struct{}{})
+}
+
+// checkPassword responds with 403 and returns false unless password is the
+// user's.
+func (apiCfg *apiConfig) checkPassword(w http.ResponseWriter, user db.User, password string) bool {
+	err := auth.CheckPassword(user.PasswordHash.String, password)
+	if errors.Is(err, auth.ErrWrongPassword) {
+		respondWithError(w, 403, "wrong password")
+		return false
+	}
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("error checking password: %v", err))
+		return false
+	}
+	return true
+}
+
+// setPassword stores a new password hash for the user and ends their
+// sessions and pending resets. dbQ should be bound to a transaction.
+func setPassword(ctx context.Context, dbQ *db.Queries, userID uuid.UUID, passwordHash string) error {
+	err := dbQ.SetUserPassword(ctx, db.SetUserPasswordParams{
+		ID: userID,
+		PasswordHash: sql.NullString{String: passwordHash, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	err = dbQ.DeleteRefreshTokensForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return dbQ.DeletePasswordResetsForUser(ctx, userID)
+}
+
+// normalizeEmail checks that email is a bare address, such as
+// "ada@example.com", and lowercases it.
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", fmt.Errorf("invalid email: %v", email)
+	}
+	return strings.ToLower(email), nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (apiCfg *apiConfig) handlerPostsForUser(w http.ResponseWriter, r *http.Request, user db.User) {
+	enclosureType := sql.NullString{}
+	if hasEnclosure := r.URL.Query().Get("has_enclosure"); hasEnclosure != "" {
+		prefix, ok := enclosureTypePrefixes[hasEnclosure]
+		if !ok {
+			respondWithError(w, 400, fmt.Sprintf("invalid has_enclosure value: %v", hasEnclosure))
+			return
+		}
+		enclosureType = sql.NullString{String: prefix, Valid: true}
+	}
+
+	q, err := parsePostsQuery(r, user)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	var posts []db.Post
+	switch q.collapse {
+	case "", "none":
+		posts, err = apiCfg.DB.GetPostsForUser(r.Context(), db.GetPostsForUserParams{
+			UserID: user.ID,
+			EnclosureType: enclosureType,
+			OldestFirst: q.sort == postSortOldest,
+			Limit: q.limit,
+			Offset: q.offset,
+		})
+	case "clusters":
+		posts, err = apiCfg.DB.GetClusteredPostsForUser(r.Context(), db.GetClusteredPostsForUserParams{
+			UserID: user.ID,
+			EnclosureType: enclosureType,
+			OldestFirst: q.sort == postSortOldest,
+			Limit: q.limit,
+			Offset: q.offset,
+		})
+	}
 	if err != nil {
 		respondWithError(w, 400, fmt.Sprintf("error getting posts for user: %v", err))
 		return
 	}
 
-	respondWithJson(w, 200, dbPostsToPosts(posts))
+	apiPosts := dbPostsToPosts(posts)
+	err = apiCfg.attachPostDetails(r.Context(), apiPosts)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if q.collapse == "clusters" {
+		err = apiCfg.attachRelatedPosts(r.Context(), user.ID, apiPosts)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+	q.present(apiPosts)
+
+	respondWithJson(w, 200, apiPosts)
+}
+
+func (apiCfg *apiConfig) attachPostDetails(ctx context.Context, posts []Post) error {
+	err := apiCfg.attachPostCategories(ctx, posts)
+	if err != nil {
+		return fmt.Errorf("error getting post categories: %v", err)
+	}
+	err = apiCfg.attachPostEnclosures(ctx, posts)
+	if err != nil {
+		return fmt.Errorf("error getting post enclosures: %v", err)
+	}
+	return nil
+}
+
+// attachRelatedPosts nests the other posts of each post's cluster, from
+// feeds the user follows, under Related.
+func (apiCfg *apiConfig) attachRelatedPosts(ctx context.Context, userID uuid.UUID, posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	clusterIDs := make([]uuid.UUID, len(posts))
+	indexByCluster := make(map[uuid.UUID]int, len(posts))
+	for i, post := range posts {
+		clusterIDs[i] = post.ClusterID
+		indexByCluster[post.ClusterID] = i
+	}
+
+	dbRelated, err := apiCfg.DB.GetPostsInClustersForUser(ctx, db.GetPostsInClustersForUserParams{
+		UserID: userID,
+		ClusterIds: clusterIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("error getting related posts: %v", err)
+	}
+	related := make([]Post, 0, len(dbRelated))
+	for _, dbPost := range dbRelated {
+		i := indexByCluster[dbPost.ClusterID]
+		if dbPost.ID != posts[i].ID {
+			related = append(related, dbPostToPost(dbPost))
+		}
+	}
+	err = apiCfg.attachPostDetails(ctx, related)
+	if err != nil {
+		return err
+	}
+	for _, post := range related {
+		i := indexByCluster[post.ClusterID]
+		posts[i].Related = append(posts[i].Related, post)
+	}
+	return nil
+}
+
+func (apiCfg *apiConfig) attachPostCategories(ctx context.Context, posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	postIDs := make([]uuid.UUID, len(posts))
+	indexByID := make(map[uuid.UUID]int, len(posts))
+	for i, post := range posts {
+		postIDs[i] = post.ID
+		indexByID[post.ID] = i
+	}
+
+	categories, err := apiCfg.DB.GetCategoriesForPosts(ctx, postIDs)
+	if err != nil {
+		return err
+	}
+	for _, category := range categories {
+		i := indexByID[category.PostID]
+		posts[i].Categories = append(posts[i].Categories, category.Name)
+	}
+	return nil
+}
+
+func (apiCfg *apiConfig) attachPostEnclosures(ctx context.Context, posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	postIDs := make([]uuid.UUID, len(posts))
+	indexByID := make(map[uuid.UUID]int, len(posts))
+	for i, post := range posts {
+		postIDs[i] = post.ID
+		indexByID[post.ID] = i
+	}
+
+	enclosures, err := apiCfg.DB.GetEnclosuresForPosts(ctx, postIDs)
+	if err != nil {
+		return err
+	}
+	for _, enclosure := range enclosures {
+		i := indexByID[enclosure.PostID]
+		posts[i].Enclosures = append(posts[i].Enclosures, dbPostEnclosureToPostEnclosure(enclosure))
+	}
+	return nil
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+func (apiCfg *apiConfig) webSubSubscription(w http.ResponseWriter, r *http.Request) (db.WebsubSubscription, bool) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		respondWithError(w, 404, "unknown subscription")
+		return db.WebsubSubscription{}, false
+	}
+	subscription, err := apiCfg.DB.GetWebSubSubscription(r.Context(), feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "unknown subscription")
+		return db.WebsubSubscription{}, false
+	}
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get subscription: %v", err))
+		return db.WebsubSubscription{}, false
+	}
+	return subscription, true
+}
+
+// handlerWebSubVerify answers the hub's verification of intent: it echoes
+// hub.challenge for subscriptions we asked for and records the lease. Only
+// a request still waiting for verification can be confirmed or denied, so
+// that anyone else can't change a lease or drop a subscription.
+func (apiCfg *apiConfig) handlerWebSubVerify(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := apiCfg.webSubSubscription(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("hub.topic") != subscription.TopicUrl {
+		respondWithError(w, 404, "unknown topic")
+		return
+	}
+	if !webSubPending(subscription) {
+		respondWithError(w, 404, "no subscription request is pending")
+		return
+	}
+
+	switch query.Get("hub.mode") {
+	case "subscribe":
+		leaseSeconds, err := strconv.ParseInt(query.Get("hub.lease_seconds"), 10, 64)
+		if err != nil || leaseSeconds <= 0 {
+			respondWithError(w, 400, "invalid hub.lease_seconds")
+			return
+		}
+		err = apiCfg.DB.ConfirmWebSubSubscription(r.Context(), db.ConfirmWebSubSubscriptionParams{
+			LeaseSeconds: int32(min(leaseSeconds, webSubMaxLeaseSeconds)),
+			FeedID:       subscription.FeedID,
+		})
+		if err != nil {
+			respondWithError(w, 400, fmt.Sprintf("couldn't confirm subscription: %v", err))
+			return
+		}
+		w.Header().Set("content-type", "text/plain")
+		w.WriteHeader(200)
+		w.Write([]byte(query.Get("hub.challenge")))
+	case "denied":
+		log.Printf("hub %v denied subscription to %v: %v", subscription.HubUrl, subscription.TopicUrl, query.Get("hub.reason"))
+		err := apiCfg.DB.DeleteWebSubSubscription(r.Context(), subscription.FeedID)
+		if err != nil {
+			respondWithError(w, 400, fmt.Sprintf("couldn't delete subscription: %v", err))
+			return
+		}
+		respondWithJson(w, 200, struct{}{})
+	default:
+		// We never unsubscribe, so any other request isn't ours.
+		respondWithError(w, 404, fmt.Sprintf("unexpected hub.mode: %v", query.Get("hub.mode")))
+	}
+}
+
+// handlerWebSubNotify ingests a feed document pushed by the hub. Content
+// with a missing or wrong X-Hub-Signature is dropped, but still answered
+// with a 2xx as the WebSub spec requires.
+func (apiCfg *apiConfig) handlerWebSubNotify(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := apiCfg.webSubSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	fetcher := apiCfg.Scraper.fetcher
+	if r.ContentLength > fetcher.maxBodySize {
+		respondWithError(w, 413, errFeedTooLarge.Error())
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, fetcher.maxBodySize+1))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error reading body: %v", err))
+		return
+	}
+	if int64(len(body)) > fetcher.maxBodySize {
+		respondWithError(w, 413, errFeedTooLarge.Error())
+		return
+	}
+
+	if !validWebSubSignature(r.Header.Get("X-Hub-Signature"), body, subscription.Secret) {
+		log.Printf("ignoring WebSub content for feed %v with an invalid signature", subscription.FeedID)
+		respondWithJson(w, 202, struct{}{})
+		return
+	}
+
+	feed, err := apiCfg.DB.GetFeed(r.Context(), subscription.FeedID)
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("couldn't get feed: %v", err))
+		return
+	}
+	rssFeed, err := fetcher.parseFeed(bytes.NewReader(body), r.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("error parsing feed: %v", err))
+		return
+	}
+
+	apiCfg.Scraper.ingestFeed(r.Context(), feed, rssFeed)
+	respondWithJson(w, 202, struct{}{})
+}
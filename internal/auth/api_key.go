@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const apiKeyPrefixLength = 8
+
+// GenerateApiKey returns a new random API key: 64 hex characters.
+func GenerateApiKey() (string, error) {
+	return randomHex(32)
+}
+
+// GenerateRefreshToken returns a new random refresh token, in the same
+// format as API keys.
+func GenerateRefreshToken() (string, error) {
+	return randomHex(32)
+}
+
+// GeneratePasswordResetToken returns a new random token for a password
+// reset link.
+func GeneratePasswordResetToken() (string, error) {
+	return randomHex(32)
+}
+
+// HashApiKey returns the hex SHA-256 hash under which a key is stored.
+// Refresh and password reset tokens are stored the same way.
+func HashApiKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// ApiKeyPrefix returns the start of a key, stored in the clear so users can
+// tell their keys apart.
+func ApiKeyPrefix(apiKey string) string {
+	if len(apiKey) < apiKeyPrefixLength {
+		return apiKey
+	}
+	return apiKey[:apiKeyPrefixLength]
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
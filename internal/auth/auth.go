@@ -6,15 +6,45 @@ import (
 	"strings"
 )
 
+var (
+	ErrNoAuthHeader        = errors.New("missing authorization header")
+	ErrMalformedAuthHeader = errors.New("malformed authorization header")
+	ErrOtherScheme         = errors.New("unsupported authorization scheme")
+)
+
+// GetAuthorization splits the Authorization header into its scheme, such
+// as "ApiKey" or "Bearer", and the credentials that follow it.
+func GetAuthorization(header http.Header) (scheme, credentials string, err error) {
+	value := strings.TrimSpace(header.Get("Authorization"))
+	if value == "" {
+		return "", "", ErrNoAuthHeader
+	}
+	scheme, credentials, ok := strings.Cut(value, " ")
+	credentials = strings.TrimSpace(credentials)
+	if !ok || credentials == "" {
+		return "", "", ErrMalformedAuthHeader
+	}
+	return scheme, credentials, nil
+}
+
 // Authorization: ApiKey <api_key>
 func GetApiKey(header http.Header) (string, error) {
-	result := header.Get("Authorization")
-	if result == "" {
-		return "", errors.New("missing authorization header")
+	return getCredentials(header, "ApiKey")
+}
+
+// Authorization: Bearer <access_token>
+func GetBearerToken(header http.Header) (string, error) {
+	return getCredentials(header, "Bearer")
+}
+
+func getCredentials(header http.Header, wantScheme string) (string, error) {
+	scheme, credentials, err := GetAuthorization(header)
+	if err != nil {
+		return "", err
 	}
-	results := strings.Split(result, " ")
-	if len(results) != 2 || results[0] != "ApiKey" {
-		return "", errors.New("invalid api key format")
+	// Schemes are case-insensitive (RFC 9110, section 11.1).
+	if !strings.EqualFold(scheme, wantScheme) {
+		return "", ErrOtherScheme
 	}
-	return results[1], nil
+	return credentials, nil
 }
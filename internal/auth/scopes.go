@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"slices"
+)
+
+const (
+	ScopeUserRead     = "user:read"
+	ScopeUserWrite    = "user:write"
+	ScopeKeysRead     = "keys:read"
+	ScopeKeysWrite    = "keys:write"
+	ScopeFeedsRead    = "feeds:read"
+	ScopeFeedsWrite   = "feeds:write"
+	ScopeFollowsRead  = "follows:read"
+	ScopeFollowsWrite = "follows:write"
+	ScopePostsRead    = "posts:read"
+	// ScopeAdmin grants every other scope as well.
+	ScopeAdmin = "admin"
+)
+
+// AllScopes are the scopes an API key can be given.
+var AllScopes = []string{
+	ScopeUserRead,
+	ScopeUserWrite,
+	ScopeKeysRead,
+	ScopeKeysWrite,
+	ScopeFeedsRead,
+	ScopeFeedsWrite,
+	ScopeFollowsRead,
+	ScopeFollowsWrite,
+	ScopePostsRead,
+	ScopeAdmin,
+}
+
+// DefaultScopes are what a new user's first key gets: everything but admin,
+// which has to be asked for explicitly.
+var DefaultScopes = slices.DeleteFunc(slices.Clone(AllScopes), func(scope string) bool {
+	return scope == ScopeAdmin
+})
+
+func ValidScope(scope string) bool {
+	return slices.Contains(AllScopes, scope)
+}
+
+// HasScope reports whether granted allows required.
+func HasScope(granted []string, required string) bool {
+	return slices.Contains(granted, required) || slices.Contains(granted, ScopeAdmin)
+}
+
+// QueryKeyScopes are the most that an API key passed in a URL can do: read
+// the posts of a feed reader subscription. URLs end up in logs, browser
+// history and Referer headers, so they mustn't open up the account.
+var QueryKeyScopes = []string{ScopePostsRead}
+
+// LimitScopes returns the scopes among allowed that granted allows.
+func LimitScopes(granted []string, allowed []string) []string {
+	scopes := []string{}
+	for _, scope := range allowed {
+		if HasScope(granted, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
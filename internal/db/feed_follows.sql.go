@@ -11,6 +11,17 @@ import (
 	"github.com/google/uuid"
 )
 
+const countFeedFollowers = `-- name: CountFeedFollowers :one
+SELECT COUNT(*) FROM feed_follows WHERE feed_id = $1
+`
+
+func (q *Queries) CountFeedFollowers(ctx context.Context, feedID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countFeedFollowers, feedID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createFeedFollow = `-- name: CreateFeedFollow :one
 INSERT INTO feed_follows (id, user_id, feed_id)
 VALUES ($1, $2, $3)
@@ -12,16 +12,17 @@ import (
 )
 
 const createFeed = `-- name: CreateFeed :one
-INSERT INTO feeds (id, name, url, user_id)
-VALUES ($1, $2, $3, $4)
-RETURNING id, name, url, user_id, created_at, updated_at, last_fetched_at
+INSERT INTO feeds (id, name, url, user_id, original_url)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, name, url, user_id, created_at, updated_at, last_fetched_at, original_url, extract_full_content_since
 `
 
 type CreateFeedParams struct {
-	ID     uuid.UUID
-	Name   string
-	Url    string
-	UserID uuid.UUID
+	ID          uuid.UUID
+	Name        string
+	Url         string
+	UserID      uuid.UUID
+	OriginalUrl string
 }
 
 func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, error) {
@@ -30,6 +31,7 @@ func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, e
 		arg.Name,
 		arg.Url,
 		arg.UserID,
+		arg.OriginalUrl,
 	)
 	var i Feed
 	err := row.Scan(
@@ -40,12 +42,80 @@ func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, e
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.LastFetchedAt,
+		&i.OriginalUrl,
+		&i.ExtractFullContentSince,
+	)
+	return i, err
+}
+
+const deleteFeed = `-- name: DeleteFeed :execrows
+DELETE FROM feeds WHERE id = $1
+`
+
+func (q *Queries) DeleteFeed(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteFeed, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getFeed = `-- name: GetFeed :one
+SELECT id, name, url, user_id, created_at, updated_at, last_fetched_at, original_url, extract_full_content_since FROM feeds
+WHERE id = $1
+`
+
+func (q *Queries) GetFeed(ctx context.Context, id uuid.UUID) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeed, id)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LastFetchedAt,
+		&i.OriginalUrl,
+		&i.ExtractFullContentSince,
+	)
+	return i, err
+}
+
+const getFeedForUser = `-- name: GetFeedForUser :one
+SELECT id, name, url, user_id, created_at, updated_at, last_fetched_at, original_url, extract_full_content_since FROM feeds
+WHERE id = $1
+AND (user_id = $2 OR EXISTS (
+    SELECT 1 FROM feed_follows
+    WHERE feed_follows.feed_id = feeds.id AND feed_follows.user_id = $2
+))
+`
+
+type GetFeedForUserParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+// Returns the feed only if the user created or follows it.
+func (q *Queries) GetFeedForUser(ctx context.Context, arg GetFeedForUserParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeedForUser, arg.ID, arg.UserID)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LastFetchedAt,
+		&i.OriginalUrl,
+		&i.ExtractFullContentSince,
 	)
 	return i, err
 }
 
 const getFeeds = `-- name: GetFeeds :many
-SELECT id, name, url, user_id, created_at, updated_at, last_fetched_at FROM feeds
+SELECT id, name, url, user_id, created_at, updated_at, last_fetched_at, original_url, extract_full_content_since FROM feeds
 `
 
 func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
@@ -65,6 +135,87 @@ func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.LastFetchedAt,
+			&i.OriginalUrl,
+			&i.ExtractFullContentSince,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFeedsCreatedByUser = `-- name: GetFeedsCreatedByUser :many
+SELECT id, name, url, user_id, created_at, updated_at, last_fetched_at, original_url, extract_full_content_since FROM feeds
+WHERE user_id = $1
+ORDER BY created_at
+`
+
+func (q *Queries) GetFeedsCreatedByUser(ctx context.Context, userID uuid.UUID) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, getFeedsCreatedByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Feed
+	for rows.Next() {
+		var i Feed
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Url,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LastFetchedAt,
+			&i.OriginalUrl,
+			&i.ExtractFullContentSince,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFeedsFollowedByUser = `-- name: GetFeedsFollowedByUser :many
+SELECT feeds.id, feeds.name, feeds.url, feeds.user_id, feeds.created_at, feeds.updated_at, feeds.last_fetched_at, feeds.original_url, feeds.extract_full_content_since FROM feeds
+JOIN feed_follows ON feed_follows.feed_id = feeds.id
+WHERE feed_follows.user_id = $1
+ORDER BY feed_follows.created_at
+`
+
+func (q *Queries) GetFeedsFollowedByUser(ctx context.Context, userID uuid.UUID) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, getFeedsFollowedByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Feed
+	for rows.Next() {
+		var i Feed
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Url,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LastFetchedAt,
+			&i.OriginalUrl,
+			&i.ExtractFullContentSince,
 		); err != nil {
 			return nil, err
 		}
@@ -80,13 +231,26 @@ func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
 }
 
 const getNextFeedsToFetch = `-- name: GetNextFeedsToFetch :many
-SELECT id, name, url, user_id, created_at, updated_at, last_fetched_at FROM feeds
-ORDER BY last_fetched_at
-ASC NULLS FIRST LIMIT $1
+SELECT feeds.id, feeds.name, feeds.url, feeds.user_id, feeds.created_at, feeds.updated_at, feeds.last_fetched_at, feeds.original_url, feeds.extract_full_content_since FROM feeds
+LEFT JOIN websub_subscriptions ON websub_subscriptions.feed_id = feeds.id
+WHERE websub_subscriptions.lease_expires_at IS NULL
+OR websub_subscriptions.lease_expires_at < NOW() + $1::int * INTERVAL '1 second'
+OR feeds.last_fetched_at IS NULL
+OR feeds.last_fetched_at < NOW() - $2::int * INTERVAL '1 second'
+ORDER BY feeds.last_fetched_at
+ASC NULLS FIRST LIMIT $3
 `
 
-func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed, error) {
-	rows, err := q.db.QueryContext(ctx, getNextFeedsToFetch, limit)
+type GetNextFeedsToFetchParams struct {
+	RenewWithinSeconds int32
+	PushedPollSeconds  int32
+	Limit              int32
+}
+
+// Feeds with an active WebSub lease get their updates pushed, so they are
+// only polled every pushed_poll_seconds, or once the lease is about to end.
+func (q *Queries) GetNextFeedsToFetch(ctx context.Context, arg GetNextFeedsToFetchParams) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, getNextFeedsToFetch, arg.RenewWithinSeconds, arg.PushedPollSeconds, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +266,8 @@ func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.LastFetchedAt,
+			&i.OriginalUrl,
+			&i.ExtractFullContentSince,
 		); err != nil {
 			return nil, err
 		}
@@ -118,10 +284,67 @@ func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed,
 
 const markFeedAsFetched = `-- name: MarkFeedAsFetched :exec
 UPDATE feeds SET last_fetched_at = NOW(), updated_at = NOW()
-WHERE id = $1 RETURNING id, name, url, user_id, created_at, updated_at, last_fetched_at
+WHERE id = $1 RETURNING id, name, url, user_id, created_at, updated_at, last_fetched_at, original_url, extract_full_content_since
 `
 
 func (q *Queries) MarkFeedAsFetched(ctx context.Context, id uuid.UUID) error {
 	_, err := q.db.ExecContext(ctx, markFeedAsFetched, id)
 	return err
 }
+
+const reassignFeedsOfUser = `-- name: ReassignFeedsOfUser :execrows
+UPDATE feeds
+SET user_id = next_owner.user_id, updated_at = NOW()
+FROM (
+    SELECT DISTINCT ON (feed_follows.feed_id) feed_follows.feed_id, feed_follows.user_id
+    FROM feed_follows
+    JOIN feeds ON feeds.id = feed_follows.feed_id
+    WHERE feeds.user_id = $1 AND feed_follows.user_id <> $1
+    ORDER BY feed_follows.feed_id, feed_follows.created_at
+) AS next_owner
+WHERE feeds.id = next_owner.feed_id
+`
+
+// Hands each feed the user created to its longest-standing other follower.
+// Feeds nobody else follows are left alone. The new owners' feeds quotas
+// aren't checked: they already follow these feeds, so scraping costs no
+// more than before, and refusing would leave the feeds without an owner.
+func (q *Queries) ReassignFeedsOfUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, reassignFeedsOfUser, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setFeedFullContentExtraction = `-- name: SetFeedFullContentExtraction :one
+UPDATE feeds SET extract_full_content_since = CASE
+    WHEN NOT $1::boolean THEN NULL
+    ELSE COALESCE(extract_full_content_since, NOW())
+END, updated_at = NOW()
+WHERE id = $2 AND user_id = $3
+RETURNING id, name, url, user_id, created_at, updated_at, last_fetched_at, original_url, extract_full_content_since
+`
+
+type SetFeedFullContentExtractionParams struct {
+	Enabled bool
+	ID      uuid.UUID
+	UserID  uuid.UUID
+}
+
+func (q *Queries) SetFeedFullContentExtraction(ctx context.Context, arg SetFeedFullContentExtractionParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, setFeedFullContentExtraction, arg.Enabled, arg.ID, arg.UserID)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LastFetchedAt,
+		&i.OriginalUrl,
+		&i.ExtractFullContentSince,
+	)
+	return i, err
+}
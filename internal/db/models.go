@@ -6,19 +6,49 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	KeyHash    string
+	Prefix     string
+	LastUsedAt sql.NullTime
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	Scopes     []string
+}
+
 type Feed struct {
+	ID                      uuid.UUID
+	Name                    string
+	Url                     string
+	UserID                  uuid.UUID
+	CreatedAt               time.Time
+	UpdatedAt               time.Time
+	LastFetchedAt           sql.NullTime
+	OriginalUrl             string
+	ExtractFullContentSince sql.NullTime
+}
+
+type FeedFetch struct {
 	ID            uuid.UUID
-	Name          string
-	Url           string
-	UserID        uuid.UUID
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	LastFetchedAt sql.NullTime
+	FeedID        uuid.UUID
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	HttpStatus    sql.NullInt32
+	Bytes         sql.NullInt64
+	DurationMs    int32
+	ItemsSeen     int32
+	PostsInserted int32
+	PostsUpdated  int32
+	Error         sql.NullString
 }
 
 type FeedFollow struct {
@@ -29,21 +59,110 @@ type FeedFollow struct {
 	UpdatedAt time.Time
 }
 
-type Post struct {
-	ID          uuid.UUID
-	FeedID      uuid.UUID
-	Title       string
-	Description sql.NullString
-	PublishedAt time.Time
-	Url         string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+type LegacyFeedUrl struct {
+	FeedID uuid.UUID
 }
 
-type User struct {
+type LegacyPostUrl struct {
+	PostID uuid.UUID
+}
+
+type PasswordReset struct {
 	ID        uuid.UUID
-	Name      string
+	UserID    uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
 	CreatedAt time.Time
+}
+
+type Post struct {
+	ID                   uuid.UUID
+	FeedID               uuid.UUID
+	Title                string
+	Description          sql.NullString
+	PublishedAt          time.Time
+	Url                  string
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	Content              sql.NullString
+	Author               sql.NullString
+	CommentsUrl          sql.NullString
+	SourceName           sql.NullString
+	SourceUrl            sql.NullString
+	ItunesEpisode        sql.NullInt32
+	ItunesSeason         sql.NullInt32
+	ItunesImage          sql.NullString
+	ItunesExplicit       sql.NullBool
+	ThumbnailUrl         sql.NullString
+	DescriptionRaw       sql.NullString
+	ContentRaw           sql.NullString
+	Summary              sql.NullString
+	WordCount            sql.NullInt32
+	ReadingTimeMinutes   sql.NullInt32
+	OriginalUrl          string
+	Fingerprint          sql.NullInt64
+	ClusterID            uuid.UUID
+	FullContent          sql.NullString
+	FullContentFetchedAt sql.NullTime
+}
+
+type PostCategory struct {
+	PostID uuid.UUID
+	Name   string
+}
+
+type PostEnclosure struct {
+	ID              uuid.UUID
+	PostID          uuid.UUID
+	Url             string
+	MimeType        string
+	Length          sql.NullInt64
+	DurationSeconds sql.NullInt32
+	CreatedAt       time.Time
+}
+
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	Allowed   bool
 	UpdatedAt time.Time
-	ApiKey    string
+}
+
+type RefreshToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	ApiKeyID  uuid.NullUUID
+	TokenHash string
+	Scopes    []string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type User struct {
+	ID                 uuid.UUID
+	Name               string
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	Email              sql.NullString
+	PasswordHash       sql.NullString
+	IsAdmin            bool
+	DisabledAt         sql.NullTime
+	MaxFeeds           sql.NullInt32
+	MaxFollows         sql.NullInt32
+	Timezone           string
+	PageSize           sql.NullInt32
+	PostSort           string
+	DisplayPreferences json.RawMessage
+}
+
+type WebsubSubscription struct {
+	FeedID         uuid.UUID
+	HubUrl         string
+	TopicUrl       string
+	Secret         string
+	RequestedAt    time.Time
+	LeaseExpiresAt sql.NullTime
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	VerifiedAt     sql.NullTime
 }
@@ -11,61 +11,172 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
-const createPost = `-- name: CreatePost :one
-INSERT INTO posts (id, feed_id, title, description, published_at, url)
-VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING id, feed_id, title, description, published_at, url, created_at, updated_at
+const findSimilarPost = `-- name: FindSimilarPost :one
+SELECT id, cluster_id FROM posts
+WHERE fingerprint IS NOT NULL
+AND published_at BETWEEN $1 AND $2
+AND feed_id <> $3
+AND bit_count((fingerprint # $4::bigint)::bit(64)) <= $5::int
+ORDER BY bit_count((fingerprint # $4::bigint)::bit(64)), published_at
+LIMIT 1
 `
 
-type CreatePostParams struct {
-	ID          uuid.UUID
+type FindSimilarPostParams struct {
+	Since       time.Time
+	Until       time.Time
 	FeedID      uuid.UUID
-	Title       string
-	Description sql.NullString
-	PublishedAt time.Time
-	Url         string
+	Fingerprint int64
+	MaxDistance int32
 }
 
-func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, error) {
-	row := q.db.QueryRowContext(ctx, createPost,
-		arg.ID,
+type FindSimilarPostRow struct {
+	ID        uuid.UUID
+	ClusterID uuid.UUID
+}
+
+// Posts from the same feed are never clustered with each other.
+func (q *Queries) FindSimilarPost(ctx context.Context, arg FindSimilarPostParams) (FindSimilarPostRow, error) {
+	row := q.db.QueryRowContext(ctx, findSimilarPost,
+		arg.Since,
+		arg.Until,
 		arg.FeedID,
-		arg.Title,
-		arg.Description,
-		arg.PublishedAt,
-		arg.Url,
-	)
-	var i Post
-	err := row.Scan(
-		&i.ID,
-		&i.FeedID,
-		&i.Title,
-		&i.Description,
-		&i.PublishedAt,
-		&i.Url,
-		&i.CreatedAt,
-		&i.UpdatedAt,
+		arg.Fingerprint,
+		arg.MaxDistance,
 	)
+	var i FindSimilarPostRow
+	err := row.Scan(&i.ID, &i.ClusterID)
 	return i, err
 }
 
+const getClusteredPostsForUser = `-- name: GetClusteredPostsForUser :many
+SELECT posts.id, posts.feed_id, posts.title, posts.description, posts.published_at, posts.url, posts.created_at, posts.updated_at, posts.content, posts.author, posts.comments_url, posts.source_name, posts.source_url, posts.itunes_episode, posts.itunes_season, posts.itunes_image, posts.itunes_explicit, posts.thumbnail_url, posts.description_raw, posts.content_raw, posts.summary, posts.word_count, posts.reading_time_minutes, posts.original_url, posts.fingerprint, posts.cluster_id, posts.full_content, posts.full_content_fetched_at FROM posts
+JOIN feed_follows ON posts.feed_id = feed_follows.feed_id
+WHERE feed_follows.user_id = $1
+AND ($2::text IS NULL OR EXISTS (
+    SELECT 1 FROM post_enclosures
+    WHERE post_enclosures.post_id = posts.id
+    AND post_enclosures.mime_type LIKE $2 || '%'
+))
+AND NOT EXISTS (
+    SELECT 1 FROM posts AS newer
+    JOIN feed_follows AS newer_follows ON newer.feed_id = newer_follows.feed_id
+    WHERE newer_follows.user_id = $1
+    AND newer.cluster_id = posts.cluster_id
+    AND ($2::text IS NULL OR EXISTS (
+        SELECT 1 FROM post_enclosures
+        WHERE post_enclosures.post_id = newer.id
+        AND post_enclosures.mime_type LIKE $2 || '%'
+    ))
+    AND (newer.published_at, newer.id) > (posts.published_at, posts.id)
+)
+ORDER BY
+    CASE WHEN $3::boolean THEN posts.published_at END ASC,
+    CASE WHEN $3::boolean THEN posts.id END ASC,
+    posts.published_at DESC, posts.id DESC
+LIMIT $4 OFFSET $5
+`
+
+type GetClusteredPostsForUserParams struct {
+	UserID        uuid.UUID
+	EnclosureType sql.NullString
+	OldestFirst   bool
+	Limit         int32
+	Offset        int32
+}
+
+func (q *Queries) GetClusteredPostsForUser(ctx context.Context, arg GetClusteredPostsForUserParams) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getClusteredPostsForUser,
+		arg.UserID,
+		arg.EnclosureType,
+		arg.OldestFirst,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.FeedID,
+			&i.Title,
+			&i.Description,
+			&i.PublishedAt,
+			&i.Url,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Content,
+			&i.Author,
+			&i.CommentsUrl,
+			&i.SourceName,
+			&i.SourceUrl,
+			&i.ItunesEpisode,
+			&i.ItunesSeason,
+			&i.ItunesImage,
+			&i.ItunesExplicit,
+			&i.ThumbnailUrl,
+			&i.DescriptionRaw,
+			&i.ContentRaw,
+			&i.Summary,
+			&i.WordCount,
+			&i.ReadingTimeMinutes,
+			&i.OriginalUrl,
+			&i.Fingerprint,
+			&i.ClusterID,
+			&i.FullContent,
+			&i.FullContentFetchedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getPostsForUser = `-- name: GetPostsForUser :many
-SELECT posts.id, posts.feed_id, posts.title, posts.description, posts.published_at, posts.url, posts.created_at, posts.updated_at FROM posts
+SELECT posts.id, posts.feed_id, posts.title, posts.description, posts.published_at, posts.url, posts.created_at, posts.updated_at, posts.content, posts.author, posts.comments_url, posts.source_name, posts.source_url, posts.itunes_episode, posts.itunes_season, posts.itunes_image, posts.itunes_explicit, posts.thumbnail_url, posts.description_raw, posts.content_raw, posts.summary, posts.word_count, posts.reading_time_minutes, posts.original_url, posts.fingerprint, posts.cluster_id, posts.full_content, posts.full_content_fetched_at FROM posts
 JOIN feed_follows ON posts.feed_id = feed_follows.feed_id
 WHERE feed_follows.user_id = $1
-ORDER BY posts.published_at DESC
-LIMIT $2
+AND ($2::text IS NULL OR EXISTS (
+    SELECT 1 FROM post_enclosures
+    WHERE post_enclosures.post_id = posts.id
+    AND post_enclosures.mime_type LIKE $2 || '%'
+))
+ORDER BY
+    CASE WHEN $3::boolean THEN posts.published_at END ASC,
+    CASE WHEN $3::boolean THEN posts.id END ASC,
+    posts.published_at DESC, posts.id DESC
+LIMIT $4 OFFSET $5
 `
 
 type GetPostsForUserParams struct {
-	UserID uuid.UUID
-	Limit  int32
+	UserID        uuid.UUID
+	EnclosureType sql.NullString
+	OldestFirst   bool
+	Limit         int32
+	Offset        int32
 }
 
 func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams) ([]Post, error) {
-	rows, err := q.db.QueryContext(ctx, getPostsForUser, arg.UserID, arg.Limit)
+	rows, err := q.db.QueryContext(ctx, getPostsForUser,
+		arg.UserID,
+		arg.EnclosureType,
+		arg.OldestFirst,
+		arg.Limit,
+		arg.Offset,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -82,6 +193,26 @@ func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams
 			&i.Url,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Content,
+			&i.Author,
+			&i.CommentsUrl,
+			&i.SourceName,
+			&i.SourceUrl,
+			&i.ItunesEpisode,
+			&i.ItunesSeason,
+			&i.ItunesImage,
+			&i.ItunesExplicit,
+			&i.ThumbnailUrl,
+			&i.DescriptionRaw,
+			&i.ContentRaw,
+			&i.Summary,
+			&i.WordCount,
+			&i.ReadingTimeMinutes,
+			&i.OriginalUrl,
+			&i.Fingerprint,
+			&i.ClusterID,
+			&i.FullContent,
+			&i.FullContentFetchedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -95,3 +226,344 @@ func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams
 	}
 	return items, nil
 }
+
+const getPostsInClustersForUser = `-- name: GetPostsInClustersForUser :many
+SELECT posts.id, posts.feed_id, posts.title, posts.description, posts.published_at, posts.url, posts.created_at, posts.updated_at, posts.content, posts.author, posts.comments_url, posts.source_name, posts.source_url, posts.itunes_episode, posts.itunes_season, posts.itunes_image, posts.itunes_explicit, posts.thumbnail_url, posts.description_raw, posts.content_raw, posts.summary, posts.word_count, posts.reading_time_minutes, posts.original_url, posts.fingerprint, posts.cluster_id, posts.full_content, posts.full_content_fetched_at FROM posts
+JOIN feed_follows ON posts.feed_id = feed_follows.feed_id
+WHERE feed_follows.user_id = $1
+AND posts.cluster_id = ANY($2::uuid[])
+ORDER BY posts.published_at DESC
+`
+
+type GetPostsInClustersForUserParams struct {
+	UserID     uuid.UUID
+	ClusterIds []uuid.UUID
+}
+
+func (q *Queries) GetPostsInClustersForUser(ctx context.Context, arg GetPostsInClustersForUserParams) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getPostsInClustersForUser, arg.UserID, pq.Array(arg.ClusterIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.FeedID,
+			&i.Title,
+			&i.Description,
+			&i.PublishedAt,
+			&i.Url,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Content,
+			&i.Author,
+			&i.CommentsUrl,
+			&i.SourceName,
+			&i.SourceUrl,
+			&i.ItunesEpisode,
+			&i.ItunesSeason,
+			&i.ItunesImage,
+			&i.ItunesExplicit,
+			&i.ThumbnailUrl,
+			&i.DescriptionRaw,
+			&i.ContentRaw,
+			&i.Summary,
+			&i.WordCount,
+			&i.ReadingTimeMinutes,
+			&i.OriginalUrl,
+			&i.Fingerprint,
+			&i.ClusterID,
+			&i.FullContent,
+			&i.FullContentFetchedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPostsPendingFullContent = `-- name: GetPostsPendingFullContent :many
+SELECT posts.id, posts.feed_id, posts.title, posts.description, posts.published_at, posts.url, posts.created_at, posts.updated_at, posts.content, posts.author, posts.comments_url, posts.source_name, posts.source_url, posts.itunes_episode, posts.itunes_season, posts.itunes_image, posts.itunes_explicit, posts.thumbnail_url, posts.description_raw, posts.content_raw, posts.summary, posts.word_count, posts.reading_time_minutes, posts.original_url, posts.fingerprint, posts.cluster_id, posts.full_content, posts.full_content_fetched_at FROM posts
+JOIN feeds ON posts.feed_id = feeds.id
+WHERE feeds.extract_full_content_since IS NOT NULL
+AND posts.created_at >= feeds.extract_full_content_since
+AND posts.full_content_fetched_at IS NULL
+ORDER BY posts.created_at
+LIMIT $1
+`
+
+func (q *Queries) GetPostsPendingFullContent(ctx context.Context, limit int32) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getPostsPendingFullContent, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.FeedID,
+			&i.Title,
+			&i.Description,
+			&i.PublishedAt,
+			&i.Url,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Content,
+			&i.Author,
+			&i.CommentsUrl,
+			&i.SourceName,
+			&i.SourceUrl,
+			&i.ItunesEpisode,
+			&i.ItunesSeason,
+			&i.ItunesImage,
+			&i.ItunesExplicit,
+			&i.ThumbnailUrl,
+			&i.DescriptionRaw,
+			&i.ContentRaw,
+			&i.Summary,
+			&i.WordCount,
+			&i.ReadingTimeMinutes,
+			&i.OriginalUrl,
+			&i.Fingerprint,
+			&i.ClusterID,
+			&i.FullContent,
+			&i.FullContentFetchedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUnsanitizedPosts = `-- name: GetUnsanitizedPosts :many
+SELECT id, feed_id, title, description, published_at, url, created_at, updated_at, content, author, comments_url, source_name, source_url, itunes_episode, itunes_season, itunes_image, itunes_explicit, thumbnail_url, description_raw, content_raw, summary, word_count, reading_time_minutes, original_url, fingerprint, cluster_id, full_content, full_content_fetched_at FROM posts
+WHERE (description IS NOT NULL AND description_raw IS NULL)
+OR (content IS NOT NULL AND content_raw IS NULL)
+LIMIT $1
+`
+
+func (q *Queries) GetUnsanitizedPosts(ctx context.Context, limit int32) ([]Post, error) {
+	rows, err := q.db.QueryContext(ctx, getUnsanitizedPosts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.FeedID,
+			&i.Title,
+			&i.Description,
+			&i.PublishedAt,
+			&i.Url,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Content,
+			&i.Author,
+			&i.CommentsUrl,
+			&i.SourceName,
+			&i.SourceUrl,
+			&i.ItunesEpisode,
+			&i.ItunesSeason,
+			&i.ItunesImage,
+			&i.ItunesExplicit,
+			&i.ThumbnailUrl,
+			&i.DescriptionRaw,
+			&i.ContentRaw,
+			&i.Summary,
+			&i.WordCount,
+			&i.ReadingTimeMinutes,
+			&i.OriginalUrl,
+			&i.Fingerprint,
+			&i.ClusterID,
+			&i.FullContent,
+			&i.FullContentFetchedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setPostCluster = `-- name: SetPostCluster :exec
+UPDATE posts SET cluster_id = $2
+WHERE id = $1
+`
+
+type SetPostClusterParams struct {
+	ID        uuid.UUID
+	ClusterID uuid.UUID
+}
+
+func (q *Queries) SetPostCluster(ctx context.Context, arg SetPostClusterParams) error {
+	_, err := q.db.ExecContext(ctx, setPostCluster, arg.ID, arg.ClusterID)
+	return err
+}
+
+const updatePostFullContent = `-- name: UpdatePostFullContent :exec
+UPDATE posts SET full_content = $2, full_content_fetched_at = NOW()
+WHERE id = $1
+`
+
+type UpdatePostFullContentParams struct {
+	ID          uuid.UUID
+	FullContent sql.NullString
+}
+
+func (q *Queries) UpdatePostFullContent(ctx context.Context, arg UpdatePostFullContentParams) error {
+	_, err := q.db.ExecContext(ctx, updatePostFullContent, arg.ID, arg.FullContent)
+	return err
+}
+
+const updatePostSanitizedContent = `-- name: UpdatePostSanitizedContent :exec
+UPDATE posts
+SET description = $2, description_raw = $3, content = $4, content_raw = $5, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdatePostSanitizedContentParams struct {
+	ID             uuid.UUID
+	Description    sql.NullString
+	DescriptionRaw sql.NullString
+	Content        sql.NullString
+	ContentRaw     sql.NullString
+}
+
+func (q *Queries) UpdatePostSanitizedContent(ctx context.Context, arg UpdatePostSanitizedContentParams) error {
+	_, err := q.db.ExecContext(ctx, updatePostSanitizedContent,
+		arg.ID,
+		arg.Description,
+		arg.DescriptionRaw,
+		arg.Content,
+		arg.ContentRaw,
+	)
+	return err
+}
+
+const upsertPost = `-- name: UpsertPost :one
+INSERT INTO posts (
+    id, feed_id, title, description, published_at, url, content, author, comments_url, source_name, source_url,
+    itunes_episode, itunes_season, itunes_image, itunes_explicit, thumbnail_url, description_raw, content_raw,
+    summary, word_count, reading_time_minutes, original_url, fingerprint, cluster_id
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
+ON CONFLICT (url) DO UPDATE SET
+    title = EXCLUDED.title,
+    description = EXCLUDED.description,
+    content = EXCLUDED.content,
+    author = EXCLUDED.author,
+    comments_url = EXCLUDED.comments_url,
+    source_name = EXCLUDED.source_name,
+    source_url = EXCLUDED.source_url,
+    itunes_episode = EXCLUDED.itunes_episode,
+    itunes_season = EXCLUDED.itunes_season,
+    itunes_image = EXCLUDED.itunes_image,
+    itunes_explicit = EXCLUDED.itunes_explicit,
+    thumbnail_url = EXCLUDED.thumbnail_url,
+    description_raw = EXCLUDED.description_raw,
+    content_raw = EXCLUDED.content_raw,
+    summary = EXCLUDED.summary,
+    word_count = EXCLUDED.word_count,
+    reading_time_minutes = EXCLUDED.reading_time_minutes,
+    original_url = EXCLUDED.original_url,
+    fingerprint = EXCLUDED.fingerprint,
+    updated_at = NOW()
+WHERE posts.feed_id = EXCLUDED.feed_id
+AND (posts.title, posts.description_raw, posts.content_raw)
+    IS DISTINCT FROM (EXCLUDED.title, EXCLUDED.description_raw, EXCLUDED.content_raw)
+RETURNING id, (xmax = 0)::boolean AS inserted
+`
+
+type UpsertPostParams struct {
+	ID                 uuid.UUID
+	FeedID             uuid.UUID
+	Title              string
+	Description        sql.NullString
+	PublishedAt        time.Time
+	Url                string
+	Content            sql.NullString
+	Author             sql.NullString
+	CommentsUrl        sql.NullString
+	SourceName         sql.NullString
+	SourceUrl          sql.NullString
+	ItunesEpisode      sql.NullInt32
+	ItunesSeason       sql.NullInt32
+	ItunesImage        sql.NullString
+	ItunesExplicit     sql.NullBool
+	ThumbnailUrl       sql.NullString
+	DescriptionRaw     sql.NullString
+	ContentRaw         sql.NullString
+	Summary            sql.NullString
+	WordCount          sql.NullInt32
+	ReadingTimeMinutes sql.NullInt32
+	OriginalUrl        string
+	Fingerprint        sql.NullInt64
+	ClusterID          uuid.UUID
+}
+
+type UpsertPostRow struct {
+	ID       uuid.UUID
+	Inserted bool
+}
+
+// Inserts a post, or updates the stored one from the same feed when its
+// title or markup changed. Returns no row when nothing changed.
+func (q *Queries) UpsertPost(ctx context.Context, arg UpsertPostParams) (UpsertPostRow, error) {
+	row := q.db.QueryRowContext(ctx, upsertPost,
+		arg.ID,
+		arg.FeedID,
+		arg.Title,
+		arg.Description,
+		arg.PublishedAt,
+		arg.Url,
+		arg.Content,
+		arg.Author,
+		arg.CommentsUrl,
+		arg.SourceName,
+		arg.SourceUrl,
+		arg.ItunesEpisode,
+		arg.ItunesSeason,
+		arg.ItunesImage,
+		arg.ItunesExplicit,
+		arg.ThumbnailUrl,
+		arg.DescriptionRaw,
+		arg.ContentRaw,
+		arg.Summary,
+		arg.WordCount,
+		arg.ReadingTimeMinutes,
+		arg.OriginalUrl,
+		arg.Fingerprint,
+		arg.ClusterID,
+	)
+	var i UpsertPostRow
+	err := row.Scan(&i.ID, &i.Inserted)
+	return i, err
+}
@@ -7,47 +7,443 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, name, api_key)
-VALUES ($1, $2, encode(sha256(random()::text::bytea), 'hex'))
-RETURNING id, name, created_at, updated_at, api_key
+INSERT INTO users (id, name, email, password_hash)
+VALUES ($1, $2, $3, $4)
+RETURNING id, name, created_at, updated_at, email, password_hash, is_admin, disabled_at, max_feeds, max_follows, timezone, page_size, post_sort, display_preferences
 `
 
 type CreateUserParams struct {
-	ID   uuid.UUID
-	Name string
+	ID           uuid.UUID
+	Name         string
+	Email        sql.NullString
+	PasswordHash sql.NullString
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.ID, arg.Name)
+	row := q.db.QueryRowContext(ctx, createUser,
+		arg.ID,
+		arg.Name,
+		arg.Email,
+		arg.PasswordHash,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Name,
 		&i.CreatedAt,
 		&i.UpdatedAt,
-		&i.ApiKey,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.DisabledAt,
+		&i.MaxFeeds,
+		&i.MaxFollows,
+		&i.Timezone,
+		&i.PageSize,
+		&i.PostSort,
+		&i.DisplayPreferences,
+	)
+	return i, err
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, name, created_at, updated_at, email, password_hash, is_admin, disabled_at, max_feeds, max_follows, timezone, page_size, post_sort, display_preferences FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.DisabledAt,
+		&i.MaxFeeds,
+		&i.MaxFollows,
+		&i.Timezone,
+		&i.PageSize,
+		&i.PostSort,
+		&i.DisplayPreferences,
 	)
 	return i, err
 }
 
 const getUserByApiKey = `-- name: GetUserByApiKey :one
-SELECT id, name, created_at, updated_at, api_key FROM users WHERE api_key = $1
+WITH used_key AS (
+    SELECT id, user_id, scopes, last_used_at FROM api_keys
+    WHERE key_hash = $1
+    AND (expires_at IS NULL OR expires_at > NOW())
+), touched_key AS (
+    UPDATE api_keys SET last_used_at = NOW()
+    FROM used_key
+    WHERE api_keys.id = used_key.id
+    AND (used_key.last_used_at IS NULL OR used_key.last_used_at < NOW() - INTERVAL '1 minute')
+)
+SELECT users.id, users.name, users.created_at, users.updated_at, users.email, users.password_hash, users.is_admin, users.disabled_at, users.max_feeds, users.max_follows, users.timezone, users.page_size, users.post_sort, users.display_preferences, used_key.id AS api_key_id, used_key.scopes FROM users
+JOIN used_key ON users.id = used_key.user_id
+`
+
+type GetUserByApiKeyRow struct {
+	User     User
+	ApiKeyID uuid.UUID
+	Scopes   []string
+}
+
+// Looks a user up by the SHA-256 hash of one of their unexpired API keys.
+// Also returns the key's id and the scopes it grants. The key is marked as
+// used at most once a minute, so that most requests authenticate without
+// writing anything.
+func (q *Queries) GetUserByApiKey(ctx context.Context, keyHash string) (GetUserByApiKeyRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserByApiKey, keyHash)
+	var i GetUserByApiKeyRow
+	err := row.Scan(
+		&i.User.ID,
+		&i.User.Name,
+		&i.User.CreatedAt,
+		&i.User.UpdatedAt,
+		&i.User.Email,
+		&i.User.PasswordHash,
+		&i.User.IsAdmin,
+		&i.User.DisabledAt,
+		&i.User.MaxFeeds,
+		&i.User.MaxFollows,
+		&i.User.Timezone,
+		&i.User.PageSize,
+		&i.User.PostSort,
+		&i.User.DisplayPreferences,
+		&i.ApiKeyID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, name, created_at, updated_at, email, password_hash, is_admin, disabled_at, max_feeds, max_follows, timezone, page_size, post_sort, display_preferences FROM users WHERE email = $1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email sql.NullString) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.DisabledAt,
+		&i.MaxFeeds,
+		&i.MaxFollows,
+		&i.Timezone,
+		&i.PageSize,
+		&i.PostSort,
+		&i.DisplayPreferences,
+	)
+	return i, err
+}
+
+const getUserQuotaUsage = `-- name: GetUserQuotaUsage :one
+SELECT
+    users.max_feeds,
+    users.max_follows,
+    (SELECT COUNT(*) FROM feeds WHERE feeds.user_id = users.id) AS feeds,
+    (SELECT COUNT(*) FROM feed_follows WHERE feed_follows.user_id = users.id) AS follows
+FROM users
+WHERE users.id = $1
+FOR UPDATE OF users
+`
+
+type GetUserQuotaUsageRow struct {
+	MaxFeeds   sql.NullInt32
+	MaxFollows sql.NullInt32
+	Feeds      int64
+	Follows    int64
+}
+
+// Returns the user's quota overrides and how many feeds and follows they
+// have. The user row stays locked until the end of the transaction, so
+// concurrent creates are checked one after another.
+func (q *Queries) GetUserQuotaUsage(ctx context.Context, id uuid.UUID) (GetUserQuotaUsageRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserQuotaUsage, id)
+	var i GetUserQuotaUsageRow
+	err := row.Scan(
+		&i.MaxFeeds,
+		&i.MaxFollows,
+		&i.Feeds,
+		&i.Follows,
+	)
+	return i, err
+}
+
+const getUsers = `-- name: GetUsers :many
+SELECT id, name, created_at, updated_at, email, password_hash, is_admin, disabled_at, max_feeds, max_follows, timezone, page_size, post_sort, display_preferences FROM users
+ORDER BY created_at
+LIMIT $1 OFFSET $2
+`
+
+type GetUsersParams struct {
+	Limit  int32
+	Offset int32
+}
+
+func (q *Queries) GetUsers(ctx context.Context, arg GetUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsers, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.PasswordHash,
+			&i.IsAdmin,
+			&i.DisabledAt,
+			&i.MaxFeeds,
+			&i.MaxFollows,
+			&i.Timezone,
+			&i.PageSize,
+			&i.PostSort,
+			&i.DisplayPreferences,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const promoteUsersToAdmin = `-- name: PromoteUsersToAdmin :execrows
+UPDATE users
+SET is_admin = TRUE, updated_at = NOW()
+WHERE id = ANY($1::uuid[]) AND NOT is_admin
+`
+
+func (q *Queries) PromoteUsersToAdmin(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, promoteUsersToAdmin, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserAdmin = `-- name: SetUserAdmin :one
+UPDATE users
+SET is_admin = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, name, created_at, updated_at, email, password_hash, is_admin, disabled_at, max_feeds, max_follows, timezone, page_size, post_sort, display_preferences
 `
 
-func (q *Queries) GetUserByApiKey(ctx context.Context, apiKey string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByApiKey, apiKey)
+type SetUserAdminParams struct {
+	ID      uuid.UUID
+	IsAdmin bool
+}
+
+func (q *Queries) SetUserAdmin(ctx context.Context, arg SetUserAdminParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserAdmin, arg.ID, arg.IsAdmin)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.DisabledAt,
+		&i.MaxFeeds,
+		&i.MaxFollows,
+		&i.Timezone,
+		&i.PageSize,
+		&i.PostSort,
+		&i.DisplayPreferences,
+	)
+	return i, err
+}
+
+const setUserDisabled = `-- name: SetUserDisabled :one
+UPDATE users
+SET disabled_at = CASE
+    WHEN NOT $1::boolean THEN NULL
+    ELSE COALESCE(disabled_at, NOW())
+END, updated_at = NOW()
+WHERE id = $2
+RETURNING id, name, created_at, updated_at, email, password_hash, is_admin, disabled_at, max_feeds, max_follows, timezone, page_size, post_sort, display_preferences
+`
+
+type SetUserDisabledParams struct {
+	Disabled bool
+	ID       uuid.UUID
+}
+
+// Disabling keeps the time it first happened.
+func (q *Queries) SetUserDisabled(ctx context.Context, arg SetUserDisabledParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserDisabled, arg.Disabled, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.DisabledAt,
+		&i.MaxFeeds,
+		&i.MaxFollows,
+		&i.Timezone,
+		&i.PageSize,
+		&i.PostSort,
+		&i.DisplayPreferences,
+	)
+	return i, err
+}
+
+const setUserEmail = `-- name: SetUserEmail :one
+UPDATE users
+SET email = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, name, created_at, updated_at, email, password_hash, is_admin, disabled_at, max_feeds, max_follows, timezone, page_size, post_sort, display_preferences
+`
+
+type SetUserEmailParams struct {
+	ID    uuid.UUID
+	Email sql.NullString
+}
+
+func (q *Queries) SetUserEmail(ctx context.Context, arg SetUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.DisabledAt,
+		&i.MaxFeeds,
+		&i.MaxFollows,
+		&i.Timezone,
+		&i.PageSize,
+		&i.PostSort,
+		&i.DisplayPreferences,
+	)
+	return i, err
+}
+
+const setUserPassword = `-- name: SetUserPassword :exec
+UPDATE users
+SET password_hash = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserPasswordParams struct {
+	ID           uuid.UUID
+	PasswordHash sql.NullString
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, setUserPassword, arg.ID, arg.PasswordHash)
+	return err
+}
+
+const setUserQuotas = `-- name: SetUserQuotas :exec
+UPDATE users
+SET max_feeds = $2, max_follows = $3, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserQuotasParams struct {
+	ID         uuid.UUID
+	MaxFeeds   sql.NullInt32
+	MaxFollows sql.NullInt32
+}
+
+func (q *Queries) SetUserQuotas(ctx context.Context, arg SetUserQuotasParams) error {
+	_, err := q.db.ExecContext(ctx, setUserQuotas, arg.ID, arg.MaxFeeds, arg.MaxFollows)
+	return err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET name = $2, timezone = $3, page_size = $4, post_sort = $5,
+    display_preferences = $6, updated_at = NOW()
+WHERE id = $1
+RETURNING id, name, created_at, updated_at, email, password_hash, is_admin, disabled_at, max_feeds, max_follows, timezone, page_size, post_sort, display_preferences
+`
+
+type UpdateUserProfileParams struct {
+	ID                 uuid.UUID
+	Name               string
+	Timezone           string
+	PageSize           sql.NullInt32
+	PostSort           string
+	DisplayPreferences json.RawMessage
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile,
+		arg.ID,
+		arg.Name,
+		arg.Timezone,
+		arg.PageSize,
+		arg.PostSort,
+		arg.DisplayPreferences,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Name,
 		&i.CreatedAt,
 		&i.UpdatedAt,
-		&i.ApiKey,
+		&i.Email,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.DisabledAt,
+		&i.MaxFeeds,
+		&i.MaxFollows,
+		&i.Timezone,
+		&i.PageSize,
+		&i.PostSort,
+		&i.DisplayPreferences,
 	)
 	return i, err
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mailer sends plain-text emails, such as password reset links.
+type mailer interface {
+	send(ctx context.Context, to, subject, body string) error
+}
+
+const defaultMailFrom = "rssagg@localhost"
+
+// newMailer picks a mailer from the environment: SMTP when SMTP_ADDR is
+// set, files in MAIL_DIR when that is, and the log otherwise.
+func newMailer() mailer {
+	from := os.Getenv("MAIL_FROM")
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		if from == "" {
+			log.Fatal("MAIL_FROM env is missing")
+		}
+		var auth smtp.Auth
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			host, _, _ := strings.Cut(addr, ":")
+			auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		return smtpMailer{addr: addr, from: from, auth: auth}
+	}
+	if from == "" {
+		from = defaultMailFrom
+	}
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		return fileMailer{dir: dir, from: from}
+	}
+	return logMailer{}
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (m smtpMailer) send(ctx context.Context, to, subject, body string) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, formatMail(m.from, to, subject, body))
+}
+
+// fileMailer writes each email to its own file in dir, for local use.
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+func (m fileMailer) send(ctx context.Context, to, subject, body string) error {
+	err := os.MkdirAll(m.dir, 0o700)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%v-%v.eml", time.Now().UTC().Format("20060102T150405.000000000"), strings.ReplaceAll(to, "/", "_"))
+	return os.WriteFile(filepath.Join(m.dir, name), formatMail(m.from, to, subject, body), 0o600)
+}
+
+// logMailer only logs emails. Since they can hold reset links, it's meant
+// for local use only.
+type logMailer struct{}
+
+func (logMailer) send(ctx context.Context, to, subject, body string) error {
+	log.Printf("mail to %v: %v\n%v", to, subject, body)
+	return nil
+}
+
+func formatMail(from, to, subject, body string) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From: %v\r\n", from)
+	fmt.Fprintf(&sb, "To: %v\r\n", to)
+	fmt.Fprintf(&sb, "Subject: %v\r\n", subject)
+	fmt.Fprintf(&sb, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	sb.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(sb.String())
+}
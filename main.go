@@ -12,14 +12,27 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/cors"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/viniciuspra/rssagg/internal/auth"
 	"github.com/viniciuspra/rssagg/internal/db"
 
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	DB *db.Queries
+	DB             *db.Queries
+	Conn           *sql.DB
+	Scraper        *scraper
+	Authenticators []authenticator
+	Tokens         *bearerTokens
+	Mailer         mailer
+	Quotas         quotaLimits
+	// RateLimiter is nil when rate limiting is off.
+	RateLimiter *rateLimiter
+	// PasswordResetURL is the page that completes a reset; the token is
+	// added to it as the "token" query parameter.
+	PasswordResetURL string
 }
 
 func main() {
@@ -55,36 +68,173 @@ func main() {
 	}
 
 	db := db.New(conn)
+
+	if adminIDs := envList("ADMIN_USER_IDS"); adminIDs != nil {
+		promoteAdmins(ctx, db, adminIDs)
+	}
+
+	guard, err := newNetworkGuard(envList("FEED_ALLOWED_NETWORKS"))
+	if err != nil {
+		log.Fatal("invalid FEED_ALLOWED_NETWORKS: ", err)
+	}
+
+	addTrackingParams(envList("TRACKING_PARAMS"))
+
+	fetcher := newFeedFetcher(
+		guard,
+		envInt64("FEED_MAX_BYTES", defaultMaxFeedSize),
+		envInt("FEED_MAX_ITEMS", defaultMaxFeedItems),
+	)
+
+	clusterer := newPostClusterer(
+		envInt("CLUSTER_MAX_DISTANCE", defaultClusterMaxDistance),
+		time.Duration(envInt("CLUSTER_WINDOW_HOURS", int(defaultClusterWindow/time.Hour)))*time.Hour,
+	)
+
+	s := &scraper{
+		db: db,
+		fetcher: fetcher,
+		clusterer: clusterer,
+		refreshes: make(chan refreshRequest, refreshQueueSize),
+		pendingRefreshes: make(map[uuid.UUID][]chan scrapeResult),
+		concurrency: 10,
+		interval: time.Minute,
+		fetchRetentionDays: envInt("FEED_FETCH_RETENTION_DAYS", defaultFetchRetentionDays),
+	}
+	if callbackBase := os.Getenv("WEBSUB_CALLBACK_URL"); callbackBase != "" {
+		s.websub = newWebSubClient(
+			db,
+			fetcher,
+			callbackBase,
+			time.Duration(envInt("WEBSUB_POLL_HOURS", int(defaultWebSubPollInterval/time.Hour)))*time.Hour,
+		)
+	}
+	go s.startScraping(ctx)
+
+	if rate := envInt("FULL_CONTENT_REQUESTS_PER_MINUTE", defaultFullContentRate); rate > 0 {
+		extractor := newContentExtractor(db, fetcher, rate)
+		go extractor.start(ctx)
+	}
+
 	apiCfg := apiConfig{
 		DB:  db,
+		Conn: conn,
+		Scraper: s,
+		Authenticators: []authenticator{apiKeyAuthenticator{db: db}},
+		Mailer: newMailer(),
+		Quotas: quotaLimits{
+			feeds: envInt("MAX_FEEDS_PER_USER", defaultMaxFeedsPerUser),
+			follows: envInt("MAX_FOLLOWS_PER_USER", defaultMaxFollowsPerUser),
+		},
+		PasswordResetURL: os.Getenv("PASSWORD_RESET_URL"),
+	}
+	if secret := os.Getenv("AUTH_TOKEN_SECRET"); secret != "" {
+		apiCfg.Tokens, err = newBearerTokens(
+			db,
+			secret,
+			time.Duration(envInt("ACCESS_TOKEN_MINUTES", int(defaultAccessTokenTTL/time.Minute)))*time.Minute,
+			time.Duration(envInt("REFRESH_TOKEN_DAYS", int(defaultRefreshTokenTTL/(24*time.Hour))))*24*time.Hour,
+		)
+		if err != nil {
+			log.Fatal("invalid AUTH_TOKEN_SECRET: ", err)
+		}
+		apiCfg.Authenticators = append(apiCfg.Authenticators, apiCfg.Tokens)
+	}
+	if envBool("AUTH_QUERY_KEYS", false) {
+		apiCfg.Authenticators = append(apiCfg.Authenticators, queryKeyAuthenticator{db: db})
 	}
 
-	go startScraping(ctx, db, 10, time.Minute)
+	rateLimit := func(next http.Handler) http.Handler { return next }
+	ipRateLimit := rateLimit
+	reads := envInt("RATE_LIMIT_READS_PER_MINUTE", defaultReadsPerMinute)
+	writes := envInt("RATE_LIMIT_WRITES_PER_MINUTE", defaultWritesPerMinute)
+	emails := envInt("RATE_LIMIT_EMAIL_PER_HOUR", defaultEmailsPerHour)
+	if reads > 0 || writes > 0 || emails > 0 {
+		limiter := &rateLimiter{
+			read: perMinute(reads),
+			write: perMinute(writes),
+			email: perHour(emails),
+			authenticate: apiCfg.authenticateOnce,
+			trustProxy: envBool("TRUST_PROXY_HEADERS", false),
+		}
+		switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+		case "", "memory":
+			limiter.store = newMemoryRateLimitStore()
+		case "postgres":
+			limiter.store = postgresRateLimitStore{db: db}
+		default:
+			log.Fatalf("invalid RATE_LIMIT_STORE: %v", store)
+		}
+		go limiter.start(ctx)
+		rateLimit = limiter.middleware
+		ipRateLimit = limiter.ipMiddleware
+		apiCfg.RateLimiter = limiter
+	}
 
 	router := chi.NewRouter()
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins: []string{"https://", "http://"},
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
-		ExposedHeaders: []string{"Link"},
+		ExposedHeaders: []string{"Link", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: false,
 		MaxAge: 300,
 	}))
 
 	v1Router := chi.NewRouter()
 	v1Router.Get("/healthz", handlerReadiness)
-	v1Router.Get("/err", handlerErr)
-	v1Router.Post("/users", apiCfg.handlerCreateUser)
-	v1Router.Get("/users", apiCfg.middlewareAuth(apiCfg.handlerGetUser))
+	// Hubs push updates for many feeds from the same few addresses, so
+	// WebSub callbacks aren't rate limited.
+	v1Router.Get("/websub/{feedID}", apiCfg.handlerWebSubVerify)
+	v1Router.Post("/websub/{feedID}", apiCfg.handlerWebSubNotify)
+
+	// Routes that don't take credentials are limited by IP address alone.
+	public := v1Router.With(ipRateLimit)
+	public.Get("/err", handlerErr)
+	if apiCfg.Tokens != nil {
+		public.Post("/auth/token", apiCfg.handlerCreateToken)
+		public.Post("/auth/revoke", apiCfg.handlerRevokeToken)
+	}
+	public.Post("/auth/login", apiCfg.handlerLogin)
+	public.Post("/auth/password-reset", apiCfg.handlerRequestPasswordReset)
+	public.Post("/auth/password-reset/confirm", apiCfg.handlerResetPassword)
+	public.Post("/users", apiCfg.handlerCreateUser)
+	public.Get("/feeds", apiCfg.handerGetFeeds)
+
+	api := v1Router.With(rateLimit)
+	api.Get("/users", apiCfg.middlewareAuth(auth.ScopeUserRead, apiCfg.handlerGetUser))
+	api.Patch("/users", apiCfg.middlewareAuth(auth.ScopeUserWrite, apiCfg.handlerUpdateUser))
+	api.Delete("/users", apiCfg.middlewareAuth(auth.ScopeUserWrite, apiCfg.handlerDeleteUser))
+	api.Get("/users/export", apiCfg.middlewareAuth(auth.ScopeUserRead, apiCfg.handlerExportUser))
+	api.Get("/users/quotas", apiCfg.middlewareAuth(auth.ScopeUserRead, apiCfg.handlerGetQuotas))
+	api.Put("/users/email", apiCfg.middlewareAuth(auth.ScopeUserWrite, apiCfg.handlerSetEmail))
+	api.Put("/users/password", apiCfg.middlewareAuth(auth.ScopeUserWrite, apiCfg.handlerChangePassword))
+	api.Post("/users/api-keys", apiCfg.middlewareAuthIdentity(auth.ScopeKeysWrite, apiCfg.handlerCreateApiKey))
+	api.Get("/users/api-keys", apiCfg.middlewareAuth(auth.ScopeKeysRead, apiCfg.handlerGetApiKeys))
+	api.Delete("/users/api-keys/{apiKeyID}", apiCfg.middlewareAuth(auth.ScopeKeysWrite, apiCfg.handlerDeleteApiKey))
+	api.Post("/users/api-keys/{apiKeyID}/rotate", apiCfg.middlewareAuthIdentity(auth.ScopeKeysWrite, apiCfg.handlerRotateApiKey))
+
+	api.Post("/feeds", apiCfg.middlewareAuth(auth.ScopeFeedsWrite, apiCfg.handlerCreateFeed))
+	api.Patch("/feeds/{feedID}", apiCfg.middlewareAuth(auth.ScopeFeedsWrite, apiCfg.handlerUpdateFeed))
+	api.Post("/feeds/{feedID}/refresh", apiCfg.middlewareAuth(auth.ScopeFeedsWrite, apiCfg.handlerRefreshFeed))
+	api.Get("/feeds/{feedID}/fetches", apiCfg.middlewareAuth(auth.ScopeFeedsRead, apiCfg.handlerGetFeedFetches))
 
-	v1Router.Post("/feeds", apiCfg.middlewareAuth(apiCfg.handlerCreateFeed))
-	v1Router.Get("/feeds", apiCfg.handerGetFeeds)
+	api.Post("/feedFollows", apiCfg.middlewareAuth(auth.ScopeFollowsWrite, apiCfg.handlerCreateFeedFollow))
+	api.Get("/feedFollows", apiCfg.middlewareAuth(auth.ScopeFollowsRead, apiCfg.handlerGetFeedFollows))
+	api.Delete("/feedFollows/{feedFollowID}", apiCfg.middlewareAuth(auth.ScopeFollowsWrite, apiCfg.handlerDeleteFeedFollow))
 
-	v1Router.Post("/feedFollows", apiCfg.middlewareAuth(apiCfg.handlerCreateFeedFollow))
-	v1Router.Get("/feedFollows", apiCfg.middlewareAuth(apiCfg.handlerGetFeedFollows))
-	v1Router.Delete("/feedFollows/{feedFollowID}", apiCfg.middlewareAuth(apiCfg.handlerDeleteFeedFollow))
+	api.Get("/posts", apiCfg.middlewareAuth(auth.ScopePostsRead, apiCfg.handlerPostsForUser))
 
-	v1Router.Get("/posts", apiCfg.middlewareAuth(apiCfg.handlerPostsForUser))
+	adminRouter := chi.NewRouter()
+	adminRouter.Get("/users", apiCfg.middlewareAdmin(apiCfg.handlerAdminGetUsers))
+	adminRouter.Patch("/users/{userID}", apiCfg.middlewareAdmin(apiCfg.handlerAdminUpdateUser))
+	adminRouter.Put("/users/{userID}/quotas", apiCfg.middlewareAdmin(apiCfg.handlerAdminSetQuotas))
+	adminRouter.Delete("/users/{userID}", apiCfg.middlewareAdmin(apiCfg.handlerAdminDeleteUser))
+	adminRouter.Get("/feeds/{feedID}/health", apiCfg.middlewareAdmin(apiCfg.handlerAdminGetFeedHealth))
+	adminRouter.Post("/feeds/{feedID}/refresh", apiCfg.middlewareAdmin(apiCfg.handlerAdminRefreshFeed))
+	adminRouter.Delete("/feeds/{feedID}", apiCfg.middlewareAdmin(apiCfg.handlerAdminDeleteFeed))
+	adminRouter.Get("/stats", apiCfg.middlewareAdmin(apiCfg.handlerAdminGetStats))
+	api.Mount("/admin", adminRouter)
 
 	router.Mount("/v1", v1Router)
 
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type RSSMediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+type RSSMediaContent struct {
+	URL        string              `xml:"url,attr"`
+	Type       string              `xml:"type,attr"`
+	Medium     string              `xml:"medium,attr"`
+	Thumbnails []RSSMediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+type RSSMediaGroup struct {
+	Contents   []RSSMediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnails []RSSMediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+func (content RSSMediaContent) isImage() bool {
+	return content.Medium == "image" || strings.HasPrefix(strings.ToLower(content.Type), "image/")
+}
+
+// thumbnailURL picks a preview image for the item: explicit Media RSS
+// thumbnails first, then image media and enclosures, and finally the first
+// <img> in the description or content. Relative URLs are resolved against
+// base, and candidates that aren't web URLs are skipped.
+func (item RSSItem) thumbnailURL(base string) string {
+	groups := append([]RSSMediaGroup{{
+		Contents:   item.MediaContents,
+		Thumbnails: item.MediaThumbnails,
+	}}, item.MediaGroups...)
+
+	for _, group := range groups {
+		for _, thumbnail := range group.Thumbnails {
+			if u := mediaURL(thumbnail.URL, base); u != "" {
+				return u
+			}
+		}
+		for _, content := range group.Contents {
+			for _, thumbnail := range content.Thumbnails {
+				if u := mediaURL(thumbnail.URL, base); u != "" {
+					return u
+				}
+			}
+		}
+	}
+	for _, group := range groups {
+		for _, content := range group.Contents {
+			if u := mediaURL(content.URL, base); u != "" && content.isImage() {
+				return u
+			}
+		}
+	}
+	for _, enclosure := range item.Enclosures {
+		if u := mediaURL(enclosure.URL, base); u != "" && strings.HasPrefix(strings.ToLower(enclosure.Type), "image/") {
+			return u
+		}
+	}
+
+	for _, fragment := range []string{item.Description, item.ContentEncoded} {
+		if src := mediaURL(firstImageSrc(fragment), base); src != "" {
+			return src
+		}
+	}
+	return ""
+}
+
+// mediaURL resolves the URL of an image or enclosure from a feed against
+// base, the way sanitizeURL does links. It returns "" for anything but an
+// absolute http or https URL.
+func mediaURL(raw, base string) string {
+	if strings.TrimSpace(raw) == "" {
+		return ""
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil || baseURL.Scheme == "" {
+		baseURL = nil
+	}
+	resolved, ok := sanitizeURL(raw, baseURL, false)
+	if !ok {
+		return ""
+	}
+	parsed, err := url.Parse(resolved)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return ""
+	}
+	return resolved
+}
+
+// firstImageSrc returns the src of the first <img> in an HTML fragment,
+// skipping 1x1 tracking pixels.
+func firstImageSrc(fragment string) string {
+	if !strings.Contains(fragment, "<img") && !strings.Contains(fragment, "<IMG") {
+		return ""
+	}
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.DataAtom != atom.Img {
+				continue
+			}
+			src, width, height := "", "", ""
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "src":
+					src = strings.TrimSpace(attr.Val)
+				case "width":
+					width = strings.TrimSpace(attr.Val)
+				case "height":
+					height = strings.TrimSpace(attr.Val)
+				}
+			}
+			if src == "" || strings.HasPrefix(src, "data:") || (width == "1" && height == "1") {
+				continue
+			}
+			return src
+		}
+	}
+}
+
+// resolveURL resolves ref against base, returning ref unchanged when either
+// cannot be parsed.
+func resolveURL(base, ref string) string {
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	baseURL, err := url.Parse(strings.TrimSpace(base))
+	if err != nil || baseURL.Scheme == "" {
+		return ref
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
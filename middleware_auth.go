@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -10,20 +13,152 @@ import (
 
 type authedHandler func(http.ResponseWriter, *http.Request, db.User)
 
-func (apiCfg *apiConfig) middlewareAuth(handler authedHandler) http.HandlerFunc {
+// identityHandler is an authedHandler that also needs to know what the
+// request's credentials allow.
+type identityHandler func(http.ResponseWriter, *http.Request, identity)
+
+// errNoCredentials is returned by an authenticator when the request doesn't
+// carry its kind of credentials, so the next one in the chain gets a go.
+var errNoCredentials = errors.New("no credentials")
+
+// identity is who a request authenticated as, and what it may do.
+type identity struct {
+	user   db.User
+	scopes []string
+}
+
+// authenticator checks one kind of credentials, such as an API key or a
+// bearer token.
+type authenticator interface {
+	authenticate(r *http.Request) (identity, error)
+}
+
+// middlewareAuth authenticates the request with the first authenticator
+// that finds credentials in it, and checks that they grant scope before
+// calling handler.
+func (apiCfg *apiConfig) middlewareAuth(scope string, handler authedHandler) http.HandlerFunc {
+	return apiCfg.middlewareAuthIdentity(scope, func(w http.ResponseWriter, r *http.Request, id identity) {
+		handler(w, r, id.user)
+	})
+}
+
+// middlewareAuthIdentity is middlewareAuth for handlers that are given the
+// whole identity, scopes included.
+func (apiCfg *apiConfig) middlewareAuthIdentity(scope string, handler identityHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		apiKey, err := auth.GetApiKey(r.Header)
+		id, err := apiCfg.authenticate(r)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, 404, err.Error())
+			return
+		}
 		if err != nil {
 			respondWithError(w, 403, fmt.Sprintf("auth error: %v", err))
 			return
 		}
 
-		user, err := apiCfg.DB.GetUserByApiKey(r.Context(), apiKey)
-		if err != nil {
-			respondWithError(w, 404, fmt.Sprintf("couldn't get user: %v", err))
+		if id.user.DisabledAt.Valid {
+			respondWithError(w, 403, "account is disabled")
 			return
 		}
 
+		if !auth.HasScope(id.scopes, scope) {
+			respondWithError(w, 403, fmt.Sprintf("credentials are missing the %v scope", scope))
+			return
+		}
+
+		handler(w, r, id)
+	}
+}
+
+// middlewareAdmin is middlewareAuth for admin-only endpoints: the user must
+// be an admin, and their credentials must have the admin scope.
+func (apiCfg *apiConfig) middlewareAdmin(handler authedHandler) http.HandlerFunc {
+	return apiCfg.middlewareAuth(auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request, user db.User) {
+		if !user.IsAdmin {
+			respondWithError(w, 403, "admin only")
+			return
+		}
 		handler(w, r, user)
+	})
+}
+
+// authResult is how authenticating a request turned out.
+type authResult struct {
+	id  identity
+	err error
+}
+
+type authResultKey struct{}
+
+// authenticateOnce authenticates r and remembers the outcome in the context
+// of the request it returns, where authenticate finds it rather than
+// checking the credentials again.
+func (apiCfg *apiConfig) authenticateOnce(r *http.Request) (*http.Request, identity, error) {
+	id, err := apiCfg.authenticate(r)
+	ctx := context.WithValue(r.Context(), authResultKey{}, authResult{id: id, err: err})
+	return r.WithContext(ctx), id, err
+}
+
+func (apiCfg *apiConfig) authenticate(r *http.Request) (identity, error) {
+	if result, ok := r.Context().Value(authResultKey{}).(authResult); ok {
+		return result.id, result.err
+	}
+	for _, authenticator := range apiCfg.Authenticators {
+		id, err := authenticator.authenticate(r)
+		if !errors.Is(err, errNoCredentials) {
+			return id, err
+		}
+	}
+	if r.Header.Get("Authorization") != "" {
+		return identity{}, auth.ErrOtherScheme
+	}
+	return identity{}, auth.ErrNoAuthHeader
+}
+
+// apiKeyAuthenticator accepts "Authorization: ApiKey <key>".
+type apiKeyAuthenticator struct {
+	db *db.Queries
+}
+
+func (a apiKeyAuthenticator) authenticate(r *http.Request) (identity, error) {
+	apiKey, err := auth.GetApiKey(r.Header)
+	if errors.Is(err, auth.ErrNoAuthHeader) || errors.Is(err, auth.ErrOtherScheme) {
+		return identity{}, errNoCredentials
+	}
+	if err != nil {
+		return identity{}, err
+	}
+	return userByApiKey(r.Context(), a.db, apiKey)
+}
+
+// queryKeyAuthenticator accepts an API key in the "key" query parameter,
+// for clients such as feed readers that can only be given a URL. Since
+// such URLs end up in logs and browser history, it only works for GET
+// requests and only with auth.QueryKeyScopes, so other routes refuse it.
+type queryKeyAuthenticator struct {
+	db *db.Queries
+}
+
+func (a queryKeyAuthenticator) authenticate(r *http.Request) (identity, error) {
+	apiKey := r.URL.Query().Get("key")
+	if apiKey == "" {
+		return identity{}, errNoCredentials
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return identity{}, errors.New("API keys in the query string only work for GET requests")
+	}
+	id, err := userByApiKey(r.Context(), a.db, apiKey)
+	if err != nil {
+		return identity{}, err
+	}
+	id.scopes = auth.LimitScopes(id.scopes, auth.QueryKeyScopes)
+	return id, nil
+}
+
+func userByApiKey(ctx context.Context, dbQ *db.Queries, apiKey string) (identity, error) {
+	row, err := dbQ.GetUserByApiKey(ctx, auth.HashApiKey(apiKey))
+	if err != nil {
+		return identity{}, fmt.Errorf("couldn't get user: %w", err)
 	}
+	return identity{user: row.User, scopes: row.Scopes}, nil
 }
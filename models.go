@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,7 +11,17 @@ import (
 type User struct {
 	ID        uuid.UUID `json:"id"`
 	Name      string `json:"name"`
-	ApiKey    string `json:"api_key"`
+	Email     *string `json:"email"`
+	HasPassword bool `json:"has_password"`
+	IsAdmin   bool `json:"is_admin"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	Timezone  string `json:"timezone"`
+	// PageSize is nil when the server default applies.
+	PageSize  *int32 `json:"page_size"`
+	PostSort  string `json:"post_sort"`
+	DisplayPreferences DisplayPreferences `json:"display_preferences"`
+	// ApiKey is only set when the user is created: keys are stored hashed.
+	ApiKey    string `json:"api_key,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -19,19 +30,70 @@ func dbUserToUser(dbUser db.User) User {
 	return User{
 		ID: dbUser.ID,
 		Name: dbUser.Name,
-		ApiKey: dbUser.ApiKey,
+		Email: nullStringToPtr(dbUser.Email),
+		HasPassword: dbUser.PasswordHash.Valid,
+		IsAdmin: dbUser.IsAdmin,
+		DisabledAt: nullTimeToPtr(dbUser.DisabledAt),
+		Timezone: dbUser.Timezone,
+		PageSize: nullInt32ToPtr(dbUser.PageSize),
+		PostSort: dbUser.PostSort,
+		DisplayPreferences: parseDisplayPreferences(dbUser.DisplayPreferences),
 		CreatedAt: dbUser.CreatedAt,
 		UpdatedAt: dbUser.UpdatedAt,
 	}
 }
 
+func dbUsersToUsers(dbUsers []db.User) []User {
+	users := make([]User, len(dbUsers))
+	for i, dbUser := range dbUsers {
+		users[i] = dbUserToUser(dbUser)
+	}
+	return users
+}
+
+type ApiKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	// Key is the plaintext key, only returned when the key is created.
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func dbApiKeyToApiKey(dbApiKey db.ApiKey) ApiKey {
+	return ApiKey{
+		ID: dbApiKey.ID,
+		Name: dbApiKey.Name,
+		Prefix: dbApiKey.Prefix,
+		Scopes: dbApiKey.Scopes,
+		LastUsedAt: nullTimeToPtr(dbApiKey.LastUsedAt),
+		ExpiresAt: nullTimeToPtr(dbApiKey.ExpiresAt),
+		CreatedAt: dbApiKey.CreatedAt,
+		UpdatedAt: dbApiKey.UpdatedAt,
+	}
+}
+
+func dbApiKeysToApiKeys(dbApiKeys []db.ApiKey) []ApiKey {
+	apiKeys := make([]ApiKey, len(dbApiKeys))
+	for i, dbApiKey := range dbApiKeys {
+		apiKeys[i] = dbApiKeyToApiKey(dbApiKey)
+	}
+	return apiKeys
+}
+
 type Feed struct {
-	ID        uuid.UUID `json:"id"`
-	Name      string `json:"name"`
-	URL       string `json:"url"`
-	UserID    uuid.UUID `json:"user_id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                 uuid.UUID `json:"id"`
+	Name               string    `json:"name"`
+	URL                string    `json:"url"`
+	OriginalURL        string    `json:"original_url"`
+	UserID             uuid.UUID `json:"user_id"`
+	ExtractFullContent bool      `json:"extract_full_content"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
 }
 
 func dbFeedToFeed(dbFeed db.Feed) Feed {
@@ -39,7 +101,9 @@ func dbFeedToFeed(dbFeed db.Feed) Feed {
 		ID: dbFeed.ID,
 		Name: dbFeed.Name,
 		URL: dbFeed.Url,
+		OriginalURL: dbFeed.OriginalUrl,
 		UserID: dbFeed.UserID,
+		ExtractFullContent: dbFeed.ExtractFullContentSince.Valid,
 		CreatedAt: dbFeed.CreatedAt,
 		UpdatedAt: dbFeed.UpdatedAt,
 	}
@@ -53,6 +117,71 @@ func dbFeedsToFeeds(dbFeeds []db.Feed) []Feed {
 	return feeds
 }
 
+type FeedRefresh struct {
+	FeedID       uuid.UUID `json:"feed_id"`
+	Status       string    `json:"status"`
+	NewPosts     *int      `json:"new_posts"`
+	UpdatedPosts *int      `json:"updated_posts"`
+	Error        *string   `json:"error"`
+}
+
+func scrapeResultToFeedRefresh(feedID uuid.UUID, result scrapeResult) FeedRefresh {
+	refresh := FeedRefresh{
+		FeedID: feedID,
+		Status: "done",
+		NewPosts: &result.newPosts,
+		UpdatedPosts: &result.updatedPosts,
+	}
+	if result.err != nil {
+		errText := result.err.Error()
+		refresh.Status = "failed"
+		refresh.Error = &errText
+	}
+	return refresh
+}
+
+type FeedFetch struct {
+	ID            uuid.UUID `json:"id"`
+	FeedID        uuid.UUID `json:"feed_id"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	HttpStatus    *int32    `json:"http_status"`
+	Bytes         *int64    `json:"bytes"`
+	DurationMs    int32     `json:"duration_ms"`
+	ItemsSeen     int32     `json:"items_seen"`
+	PostsInserted int32     `json:"posts_inserted"`
+	PostsUpdated  int32     `json:"posts_updated"`
+	Error         *string   `json:"error"`
+}
+
+func dbFeedFetchToFeedFetch(dbFeedFetch db.FeedFetch) FeedFetch {
+	var bytes *int64
+	if dbFeedFetch.Bytes.Valid {
+		bytes = &dbFeedFetch.Bytes.Int64
+	}
+	return FeedFetch{
+		ID: dbFeedFetch.ID,
+		FeedID: dbFeedFetch.FeedID,
+		StartedAt: dbFeedFetch.StartedAt,
+		FinishedAt: dbFeedFetch.FinishedAt,
+		HttpStatus: nullInt32ToPtr(dbFeedFetch.HttpStatus),
+		Bytes: bytes,
+		DurationMs: dbFeedFetch.DurationMs,
+		ItemsSeen: dbFeedFetch.ItemsSeen,
+		PostsInserted: dbFeedFetch.PostsInserted,
+		PostsUpdated: dbFeedFetch.PostsUpdated,
+		Error: nullStringToPtr(dbFeedFetch.Error),
+	}
+}
+
+func dbFeedFetchesToFeedFetches(dbFeedFetches []db.FeedFetch) []FeedFetch {
+	feedFetches := make([]FeedFetch, len(dbFeedFetches))
+	for i, dbFeedFetch := range dbFeedFetches {
+		feedFetches[i] = dbFeedFetchToFeedFetch(dbFeedFetch)
+	}
+	return feedFetches
+}
+
 type FeedFollow struct {
 	ID        uuid.UUID `json:"id"`
 	UserID    uuid.UUID `json:"user_id"`
@@ -80,28 +209,89 @@ func dbFeedFollowsToFeedFollows(dbFeedFollows []db.FeedFollow) []FeedFollow {
 }
 
 type Post struct {
-	ID          uuid.UUID `json:"id"`
-	FeedID      uuid.UUID `json:"feed_id"`
-	Title       string    `json:"title"`
-	Description *string   `json:"description"`
-	PublishedAt time.Time `json:"published_at"`
-	Url         string    `json:"url"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                 uuid.UUID       `json:"id"`
+	FeedID             uuid.UUID       `json:"feed_id"`
+	Title              string          `json:"title"`
+	Description        *string         `json:"description"`
+	Content            *string         `json:"content"`
+	FullContent        *string         `json:"full_content"`
+	Author             *string         `json:"author"`
+	Categories         []string        `json:"categories"`
+	CommentsUrl        *string         `json:"comments_url"`
+	Source             *PostSource     `json:"source"`
+	Enclosures         []PostEnclosure `json:"enclosures"`
+	Podcast            *PostPodcast    `json:"podcast"`
+	ThumbnailUrl       *string         `json:"thumbnail_url"`
+	Summary            *string         `json:"summary"`
+	WordCount          *int32          `json:"word_count"`
+	ReadingTimeMinutes *int32          `json:"reading_time_minutes"`
+	PublishedAt        time.Time       `json:"published_at"`
+	Url                string          `json:"url"`
+	OriginalUrl        string          `json:"original_url"`
+	ClusterID          uuid.UUID       `json:"cluster_id"`
+	Related            []Post          `json:"related,omitempty"`
+	CreatedAt          time.Time       `json:"created_at"`
+	UpdatedAt          time.Time       `json:"updated_at"`
+}
+
+type PostSource struct {
+	Name *string `json:"name"`
+	Url  *string `json:"url"`
+}
+
+type PostEnclosure struct {
+	ID              uuid.UUID `json:"id"`
+	Url             string    `json:"url"`
+	MimeType        string    `json:"mime_type"`
+	Length          *int64    `json:"length"`
+	DurationSeconds *int32    `json:"duration_seconds"`
+}
+
+type PostPodcast struct {
+	Episode  *int32  `json:"episode"`
+	Season   *int32  `json:"season"`
+	Image    *string `json:"image"`
+	Explicit *bool   `json:"explicit"`
 }
 
 func dbPostToPost(dbPost db.Post) Post {
-	var description *string
-	if dbPost.Description.Valid {
-		description = &dbPost.Description.String
+	var source *PostSource
+	if dbPost.SourceName.Valid || dbPost.SourceUrl.Valid {
+		source = &PostSource{
+			Name: nullStringToPtr(dbPost.SourceName),
+			Url: nullStringToPtr(dbPost.SourceUrl),
+		}
+	}
+	var podcast *PostPodcast
+	if dbPost.ItunesEpisode.Valid || dbPost.ItunesSeason.Valid || dbPost.ItunesImage.Valid || dbPost.ItunesExplicit.Valid {
+		podcast = &PostPodcast{
+			Episode: nullInt32ToPtr(dbPost.ItunesEpisode),
+			Season: nullInt32ToPtr(dbPost.ItunesSeason),
+			Image: nullStringToPtr(dbPost.ItunesImage),
+			Explicit: nullBoolToPtr(dbPost.ItunesExplicit),
+		}
 	}
 	return Post{
 		ID: dbPost.ID,
 		FeedID: dbPost.FeedID,
 		Title: dbPost.Title,
-		Description: description,
+		Description: nullStringToPtr(dbPost.Description),
+		Content: nullStringToPtr(dbPost.Content),
+		FullContent: nullStringToPtr(dbPost.FullContent),
+		Author: nullStringToPtr(dbPost.Author),
+		Categories: []string{},
+		CommentsUrl: nullStringToPtr(dbPost.CommentsUrl),
+		Source: source,
+		Enclosures: []PostEnclosure{},
+		Podcast: podcast,
+		ThumbnailUrl: nullStringToPtr(dbPost.ThumbnailUrl),
+		Summary: nullStringToPtr(dbPost.Summary),
+		WordCount: nullInt32ToPtr(dbPost.WordCount),
+		ReadingTimeMinutes: nullInt32ToPtr(dbPost.ReadingTimeMinutes),
 		PublishedAt: dbPost.PublishedAt,
 		Url: dbPost.Url,
+		OriginalUrl: dbPost.OriginalUrl,
+		ClusterID: dbPost.ClusterID,
 		CreatedAt: dbPost.CreatedAt,
 		UpdatedAt: dbPost.UpdatedAt,
 	}
@@ -114,3 +304,118 @@ func dbPostsToPosts(dbPosts []db.Post) []Post {
 	}
 	return posts
 }
+
+func nullStringToPtr(ns sql.NullString) *string {
+	if !ns.Valid {
+		return nil
+	}
+	return &ns.String
+}
+
+func dbPostEnclosureToPostEnclosure(dbEnclosure db.PostEnclosure) PostEnclosure {
+	var length *int64
+	if dbEnclosure.Length.Valid {
+		length = &dbEnclosure.Length.Int64
+	}
+	return PostEnclosure{
+		ID: dbEnclosure.ID,
+		Url: dbEnclosure.Url,
+		MimeType: dbEnclosure.MimeType,
+		Length: length,
+		DurationSeconds: nullInt32ToPtr(dbEnclosure.DurationSeconds),
+	}
+}
+
+func nullInt32ToPtr(ni sql.NullInt32) *int32 {
+	if !ni.Valid {
+		return nil
+	}
+	return &ni.Int32
+}
+
+func nullBoolToPtr(nb sql.NullBool) *bool {
+	if !nb.Valid {
+		return nil
+	}
+	return &nb.Bool
+}
+
+func nullTimeToPtr(nt sql.NullTime) *time.Time {
+	if !nt.Valid {
+		return nil
+	}
+	return &nt.Time
+}
+
+// Token is the response of POST /v1/auth/token and /v1/auth/login. Scopes
+// are those of the API key the session started from, or all of them after
+// a login.
+type Token struct {
+	AccessToken  string   `json:"access_token"`
+	TokenType    string   `json:"token_type"`
+	ExpiresIn    int      `json:"expires_in"`
+	RefreshToken string   `json:"refresh_token"`
+	Scopes       []string `json:"scopes"`
+}
+
+// FeedHealth is an admin's view of how fetching a feed is going. Counts
+// cover the fetch history still kept.
+type FeedHealth struct {
+	Feed                Feed       `json:"feed"`
+	Followers           int64      `json:"followers"`
+	Fetches             int64      `json:"fetches"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int64      `json:"consecutive_failures"`
+	LastFetch           *FeedFetch `json:"last_fetch"`
+	LastSuccess         *FeedFetch `json:"last_success"`
+	WebSubHub           *string    `json:"websub_hub"`
+	WebSubLeaseExpires  *time.Time `json:"websub_lease_expires_at"`
+}
+
+type SystemStats struct {
+	Users               int64 `json:"users"`
+	Admins              int64 `json:"admins"`
+	DisabledUsers       int64 `json:"disabled_users"`
+	Feeds               int64 `json:"feeds"`
+	FeedsNeverFetched   int64 `json:"feeds_never_fetched"`
+	WebSubSubscriptions int64 `json:"websub_subscriptions"`
+	FeedFollows         int64 `json:"feed_follows"`
+	Posts               int64 `json:"posts"`
+	PostsLastDay        int64 `json:"posts_last_day"`
+	RefreshQueueDepth   int   `json:"refresh_queue_depth"`
+	RefreshQueueSize    int   `json:"refresh_queue_size"`
+}
+
+func dbSystemStatsToSystemStats(dbStats db.GetSystemStatsRow) SystemStats {
+	return SystemStats{
+		Users:               dbStats.Users,
+		Admins:              dbStats.Admins,
+		DisabledUsers:       dbStats.DisabledUsers,
+		Feeds:               dbStats.Feeds,
+		FeedsNeverFetched:   dbStats.FeedsNeverFetched,
+		WebSubSubscriptions: dbStats.WebsubSubscriptions,
+		FeedFollows:         dbStats.FeedFollows,
+		Posts:               dbStats.Posts,
+		PostsLastDay:        dbStats.PostsLastDay,
+	}
+}
+
+// Quota is how much of a per-user limit is used. A nil Limit means there
+// is none.
+type Quota struct {
+	Used  int64  `json:"used"`
+	Limit *int64 `json:"limit"`
+}
+
+type UserQuotas struct {
+	Feeds   Quota `json:"feeds"`
+	Follows Quota `json:"follows"`
+}
+
+// QuotaExceeded is the 403 body of creates that would go over a quota.
+type QuotaExceeded struct {
+	Error string `json:"error"`
+	Quota string `json:"quota"`
+	Used  int64  `json:"used"`
+	Limit int64  `json:"limit"`
+}
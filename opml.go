@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+// opml is an OPML 2.0 subscription list, the format feed readers import
+// and export.
+type opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    opmlHead `xml:"head"`
+	Body    opmlBody `xml:"body"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated"`
+}
+
+type opmlBody struct {
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlOutline struct {
+	Type   string `xml:"type,attr"`
+	Text   string `xml:"text,attr"`
+	Title  string `xml:"title,attr"`
+	XMLURL string `xml:"xmlUrl,attr"`
+}
+
+// feedsToOPML lists feeds by the URL they were added with.
+func feedsToOPML(title string, feeds []db.Feed) ([]byte, error) {
+	doc := opml{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, feed := range feeds {
+		doc.Body.Outlines = append(doc.Body.Outlines, opmlOutline{
+			Type:   "rss",
+			Text:   feed.Name,
+			Title:  feed.Name,
+			XMLURL: feed.OriginalUrl,
+		})
+	}
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+type RSSEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type RSSItunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// parseItunesDuration accepts the "HH:MM:SS", "MM:SS" and plain seconds
+// forms allowed for itunes:duration.
+func parseItunesDuration(duration string) sql.NullInt32 {
+	duration = strings.TrimSpace(duration)
+	if duration == "" {
+		return sql.NullInt32{}
+	}
+
+	parts := strings.Split(duration, ":")
+	if len(parts) > 3 {
+		return sql.NullInt32{}
+	}
+	seconds := 0
+	for _, part := range parts {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || value < 0 {
+			return sql.NullInt32{}
+		}
+		seconds = seconds*60 + value
+	}
+	return sql.NullInt32{Int32: int32(seconds), Valid: true}
+}
+
+func parseItunesExplicit(explicit string) sql.NullBool {
+	switch strings.ToLower(strings.TrimSpace(explicit)) {
+	case "yes", "true", "explicit":
+		return sql.NullBool{Bool: true, Valid: true}
+	case "no", "false", "clean":
+		return sql.NullBool{Bool: false, Valid: true}
+	}
+	return sql.NullBool{}
+}
+
+func parseNullInt32(value string) sql.NullInt32 {
+	parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: int32(parsed), Valid: true}
+}
+
+func parseNullInt64(value string) sql.NullInt64 {
+	parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || parsed <= 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: parsed, Valid: true}
+}
+
+// enclosureTypePrefixes maps the has_enclosure filter values of
+// GET /v1/posts to MIME type prefixes; an empty prefix matches any type.
+var enclosureTypePrefixes = map[string]string{
+	"any":   "",
+	"true":  "",
+	"audio": "audio/",
+	"video": "video/",
+	"image": "image/",
+}
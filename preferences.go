@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	// Embedded so that time zones work on hosts without a zoneinfo database.
+	_ "time/tzdata"
+
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+// Orders for GET /posts, by publication date.
+const (
+	postSortNewest = "newest"
+	postSortOldest = "oldest"
+)
+
+// How much of each post GET /posts returns.
+const (
+	// postContentFull returns everything.
+	postContentFull = "full"
+	// postContentSummary leaves out content and full_content.
+	postContentSummary = "summary"
+	// postContentTitle also leaves out description and summary.
+	postContentTitle = "title"
+)
+
+// DisplayPreferences are the defaults for how GET /posts presents posts.
+// They are stored as JSON, so fields can be added without a migration.
+type DisplayPreferences struct {
+	// Content is one of the postContent values; empty means full.
+	Content string `json:"content,omitempty"`
+	// Collapse is a default for the collapse query parameter.
+	Collapse string `json:"collapse,omitempty"`
+}
+
+func (p DisplayPreferences) validate() error {
+	if err := validatePostContent(p.Content); err != nil {
+		return err
+	}
+	return validateCollapse(p.Collapse)
+}
+
+func validatePostSort(sort string) error {
+	if sort != postSortNewest && sort != postSortOldest {
+		return fmt.Errorf("invalid sort value: %v", sort)
+	}
+	return nil
+}
+
+func validatePostContent(content string) error {
+	switch content {
+	case "", postContentFull, postContentSummary, postContentTitle:
+		return nil
+	}
+	return fmt.Errorf("invalid content value: %v", content)
+}
+
+func validateCollapse(collapse string) error {
+	switch collapse {
+	case "", "none", "clusters":
+		return nil
+	}
+	return fmt.Errorf("invalid collapse value: %v", collapse)
+}
+
+// mergeDisplayPreferences applies the fields set in patch to the stored
+// preferences. Unknown fields are rejected, so typos don't go unnoticed.
+func mergeDisplayPreferences(stored json.RawMessage, patch json.RawMessage) (json.RawMessage, error) {
+	prefs := parseDisplayPreferences(stored)
+	decoder := json.NewDecoder(bytes.NewReader(patch))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&prefs); err != nil {
+		return nil, fmt.Errorf("invalid display_preferences: %v", err)
+	}
+	if err := prefs.validate(); err != nil {
+		return nil, fmt.Errorf("invalid display_preferences: %v", err)
+	}
+	return json.Marshal(prefs)
+}
+
+// parseDisplayPreferences reads preferences stored by
+// mergeDisplayPreferences, which were valid when they were written.
+func parseDisplayPreferences(stored json.RawMessage) DisplayPreferences {
+	prefs := DisplayPreferences{}
+	if err := json.Unmarshal(stored, &prefs); err != nil {
+		return DisplayPreferences{}
+	}
+	return prefs
+}
+
+// postsQuery is how GET /posts should list posts: the user's defaults,
+// overridden by any query parameters.
+type postsQuery struct {
+	limit    int32
+	offset   int32
+	sort     string
+	collapse string
+	content  string
+	location *time.Location
+}
+
+func parsePostsQuery(r *http.Request, user db.User) (postsQuery, error) {
+	prefs := parseDisplayPreferences(user.DisplayPreferences)
+	pageSize := int32(defaultPostsPageSize)
+	if user.PageSize.Valid {
+		pageSize = user.PageSize.Int32
+	}
+	q := postsQuery{
+		sort:     user.PostSort,
+		collapse: prefs.Collapse,
+		content:  prefs.Content,
+	}
+
+	var err error
+	q.limit, q.offset, err = parsePaginationWithDefault(r, pageSize)
+	if err != nil {
+		return postsQuery{}, err
+	}
+	query := r.URL.Query()
+	if sort := query.Get("sort"); sort != "" {
+		if err := validatePostSort(sort); err != nil {
+			return postsQuery{}, err
+		}
+		q.sort = sort
+	}
+	if query.Has("collapse") {
+		q.collapse = query.Get("collapse")
+		if err := validateCollapse(q.collapse); err != nil {
+			return postsQuery{}, err
+		}
+	}
+	if content := query.Get("content"); content != "" {
+		if err := validatePostContent(content); err != nil {
+			return postsQuery{}, err
+		}
+		q.content = content
+	}
+	timezone := user.Timezone
+	if tz := query.Get("tz"); tz != "" {
+		timezone = tz
+	}
+	q.location, err = loadTimezone(timezone)
+	if err != nil {
+		return postsQuery{}, err
+	}
+	return q, nil
+}
+
+// loadTimezone loads an IANA time zone such as "America/Sao_Paulo". The
+// server's own "Local" zone isn't one users can pick.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("invalid timezone: %q", name)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %q", name)
+	}
+	return location, nil
+}
+
+// present trims posts to q.content and gives their times in q.location.
+func (q postsQuery) present(posts []Post) {
+	for i := range posts {
+		post := &posts[i]
+		switch q.content {
+		case postContentTitle:
+			post.Description = nil
+			post.Summary = nil
+			fallthrough
+		case postContentSummary:
+			post.Content = nil
+			post.FullContent = nil
+		}
+		post.PublishedAt = post.PublishedAt.In(q.location)
+		post.CreatedAt = post.CreatedAt.In(q.location)
+		post.UpdatedAt = post.UpdatedAt.In(q.location)
+		q.present(post.Related)
+	}
+}
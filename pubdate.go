@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var errMissingPubDate = errors.New("missing publication date")
+
+// pubDateLayouts are tried in order after normalizePubDate has removed the
+// weekday and replaced named time zones with numeric offsets. Go's "2" also
+// accepts zero-padded days and "06" expands two-digit years.
+var pubDateLayouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05 -07:00",
+	"2 Jan 2006 15:04 -07:00",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04 -0700",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04 -0700",
+	"2 January 2006 15:04:05 -07:00",
+	"2 January 2006 15:04 -07:00",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"2 January 2006 15:04:05",
+	"2 Jan 2006",
+	"2 January 2006",
+	"Jan 2 2006 15:04:05 -0700",
+	"Jan 2 2006 15:04:05",
+	"January 2 2006 15:04:05 -0700",
+	"January 2 2006",
+	"Mon Jan 2 15:04:05 -0700 2006",
+	"Mon Jan 2 15:04:05 2006",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// zoneOffsets maps the time zone abbreviations seen in feeds to offsets;
+// time.Parse would otherwise treat unknown abbreviations as UTC.
+var zoneOffsets = map[string]string{
+	"UT":   "+0000",
+	"UTC":  "+0000",
+	"GMT":  "+0000",
+	"Z":    "+0000",
+	"WET":  "+0000",
+	"WEST": "+0100",
+	"BST":  "+0100",
+	"CET":  "+0100",
+	"CEST": "+0200",
+	"EET":  "+0200",
+	"EEST": "+0300",
+	"MSK":  "+0300",
+	"IST":  "+0530",
+	"JST":  "+0900",
+	"KST":  "+0900",
+	"AEST": "+1000",
+	"AEDT": "+1100",
+	"NZST": "+1200",
+	"NZDT": "+1300",
+	"EST":  "-0500",
+	"EDT":  "-0400",
+	"CST":  "-0600",
+	"CDT":  "-0500",
+	"MST":  "-0700",
+	"MDT":  "-0600",
+	"PST":  "-0800",
+	"PDT":  "-0700",
+	"AKST": "-0900",
+	"AKDT": "-0800",
+	"HST":  "-1000",
+}
+
+func parsePubDate(pubDate string) (time.Time, error) {
+	normalized := normalizePubDate(pubDate)
+	if normalized == "" {
+		return time.Time{}, errMissingPubDate
+	}
+
+	for _, layout := range pubDateLayouts {
+		parsedDate, err := time.Parse(layout, normalized)
+		if err == nil {
+			return parsedDate.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized date format %q", pubDate)
+}
+
+func normalizePubDate(pubDate string) string {
+	fields := strings.Fields(pubDate)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	// "Mon," / "Monday," carries no information and is often misspelled.
+	if strings.HasSuffix(fields[0], ",") && isLetters(strings.TrimSuffix(fields[0], ",")) {
+		fields = fields[1:]
+	}
+
+	for i, field := range fields {
+		field = strings.TrimSuffix(field, ",")
+		if offset, ok := zoneOffsets[strings.ToUpper(field)]; ok && i > 0 {
+			field = offset
+		}
+		if strings.EqualFold(field, "Sept") {
+			field = "Sep"
+		}
+		fields[i] = field
+	}
+
+	return strings.Join(fields, " ")
+}
+
+func isLetters(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
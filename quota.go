@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const (
+	defaultMaxFeedsPerUser   = 100
+	defaultMaxFollowsPerUser = 500
+)
+
+// quotaLimits are the default per-user limits; 0 means unlimited.
+type quotaLimits struct {
+	feeds   int
+	follows int
+}
+
+// userQuotas combines the user's usage with their overrides, falling back
+// to the defaults.
+func (l quotaLimits) userQuotas(usage db.GetUserQuotaUsageRow) UserQuotas {
+	return UserQuotas{
+		Feeds:   Quota{Used: usage.Feeds, Limit: quotaLimit(usage.MaxFeeds, l.feeds)},
+		Follows: Quota{Used: usage.Follows, Limit: quotaLimit(usage.MaxFollows, l.follows)},
+	}
+}
+
+func quotaLimit(override sql.NullInt32, fallback int) *int64 {
+	if override.Valid {
+		limit := int64(override.Int32)
+		return &limit
+	}
+	if fallback == 0 {
+		return nil
+	}
+	limit := int64(fallback)
+	return &limit
+}
+
+// respondIfQuotaReached responds with 403 and returns true when quota has
+// no room for one more.
+func respondIfQuotaReached(w http.ResponseWriter, name string, quota Quota) bool {
+	if quota.Limit == nil || quota.Used < *quota.Limit {
+		return false
+	}
+	respondWithJson(w, 403, QuotaExceeded{
+		Error: fmt.Sprintf("%v quota reached: %d of %d used", name, quota.Used, *quota.Limit),
+		Quota: name,
+		Used:  quota.Used,
+		Limit: *quota.Limit,
+	})
+	return true
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/viniciuspra/rssagg/internal/auth"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const (
+	defaultReadsPerMinute  = 120
+	defaultWritesPerMinute = 30
+	defaultEmailsPerHour   = 10
+	rateLimitPruneInterval = time.Minute
+)
+
+// rateLimit is a token bucket that holds up to burst tokens and refills at
+// perSecond.
+type rateLimit struct {
+	burst     float64
+	perSecond float64
+}
+
+// perMinute returns a bucket that holds a minute's worth of requests. Zero
+// requests means no limit.
+func perMinute(requests int) rateLimit {
+	return rateLimit{burst: float64(requests), perSecond: float64(requests) / 60}
+}
+
+// perHour returns a bucket that holds an hour's worth of requests. Zero
+// requests means no limit.
+func perHour(requests int) rateLimit {
+	return rateLimit{burst: float64(requests), perSecond: float64(requests) / 3600}
+}
+
+// fillTime is how long an empty bucket takes to refill completely.
+func (l rateLimit) fillTime() time.Duration {
+	if l.perSecond == 0 {
+		return 0
+	}
+	return time.Duration(l.burst / l.perSecond * float64(time.Second))
+}
+
+// rateLimitStore keeps the buckets. take refills the bucket at key for the
+// time since it was last used and takes a token if a whole one is left,
+// returning the tokens left and whether one was taken.
+type rateLimitStore interface {
+	take(ctx context.Context, key string, limit rateLimit) (tokens float64, allowed bool, err error)
+	prune(ctx context.Context, idle time.Duration) error
+}
+
+// rateLimiter gives each client separate budgets for read (GET and HEAD)
+// and write requests. Clients are told apart by the user their credentials
+// verify as, falling back to their IP address. On top of that, email
+// limits how often a single email address can be logged into or sent a
+// password reset.
+type rateLimiter struct {
+	store rateLimitStore
+	read  rateLimit
+	write rateLimit
+	email rateLimit
+	// authenticate checks the request's credentials, returning the request
+	// with the outcome remembered for the handler.
+	authenticate func(*http.Request) (*http.Request, identity, error)
+	trustProxy   bool
+}
+
+// start prunes idle buckets until ctx is done.
+func (l *rateLimiter) start(ctx context.Context) {
+	// A bucket left alone this long has refilled completely.
+	idle := max(l.read.fillTime(), l.write.fillTime(), l.email.fillTime())
+
+	ticker := time.NewTicker(rateLimitPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.store.prune(ctx, idle); err != nil {
+				log.Println("error pruning rate limit buckets:", err)
+			}
+		}
+	}
+}
+
+// middleware limits requests by the user of valid credentials, or by IP
+// address for requests without. Only credentials that check out count, so
+// made-up ones can't be used to get fresh buckets.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget, limit := l.budget(r)
+		if limit.burst == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := "ip:" + l.clientIP(r)
+		r, id, err := l.authenticate(r)
+		if err == nil {
+			key = "user:" + id.user.ID.String()
+		}
+		if l.limitRequest(w, r, budget+":"+key, limit) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// ipMiddleware limits requests by IP address alone. It is for routes that
+// don't take credentials, such as signing up and logging in.
+func (l *rateLimiter) ipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget, limit := l.budget(r)
+		if limit.burst == 0 || l.limitRequest(w, r, budget+":ip:"+l.clientIP(r), limit) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (l *rateLimiter) budget(r *http.Request) (string, rateLimit) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return "read", l.read
+	}
+	return "write", l.write
+}
+
+// limitRequest takes a token from the bucket at key and sets the rate
+// limit headers. false means the bucket was empty and it responded 429.
+func (l *rateLimiter) limitRequest(w http.ResponseWriter, r *http.Request, key string, limit rateLimit) bool {
+	tokens, allowed := l.take(r.Context(), key, limit)
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(int(limit.burst)))
+	header.Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))))
+	header.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil((limit.burst-tokens)/limit.perSecond))))
+	if !allowed {
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)/limit.perSecond))))
+		respondWithError(w, 429, "rate limit exceeded, try again later")
+		return false
+	}
+	return true
+}
+
+// allowEmail limits attempts at action, such as logging in, for one email
+// address however many clients they come from. false means there have
+// been too many and it responded 429.
+func (l *rateLimiter) allowEmail(w http.ResponseWriter, r *http.Request, action, email string) bool {
+	if l == nil || l.email.burst == 0 {
+		return true
+	}
+	// Addresses are hashed so that the store never holds them.
+	tokens, allowed := l.take(r.Context(), action+":email:"+auth.HashApiKey(email), l.email)
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil((1-tokens)/l.email.perSecond))))
+		respondWithError(w, 429, "too many attempts for this email, try again later")
+		return false
+	}
+	return true
+}
+
+// take takes a token from the bucket at key. Store errors let the request
+// through: better that than failing all of them.
+func (l *rateLimiter) take(ctx context.Context, key string, limit rateLimit) (tokens float64, allowed bool) {
+	tokens, allowed, err := l.store.take(ctx, key, limit)
+	if err != nil {
+		log.Println("rate limiter error:", err)
+		return limit.burst, true
+	}
+	return tokens, allowed
+}
+
+// clientIP returns the address the request came from. Behind a reverse
+// proxy that is the proxy's, so with trustProxy the last address in
+// X-Forwarded-For is used instead: the one the proxy appended. Addresses
+// before it come from the client, which can write anything there.
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	if l.trustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			if last := strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:]); last != "" {
+				return last
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// memoryRateLimitStore keeps buckets in memory, so each replica has its own.
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*tokenBucket)}
+}
+
+func (s *memoryRateLimitStore) take(ctx context.Context, key string, limit rateLimit) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: limit.burst, updatedAt: now}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = min(limit.burst, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*limit.perSecond)
+	bucket.updatedAt = now
+	if bucket.tokens < 1 {
+		return bucket.tokens, false, nil
+	}
+	bucket.tokens--
+	return bucket.tokens, true, nil
+}
+
+func (s *memoryRateLimitStore) prune(ctx context.Context, idle time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, bucket := range s.buckets {
+		if time.Since(bucket.updatedAt) > idle {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
+
+// postgresRateLimitStore keeps buckets in the database, so that replicas
+// share them.
+type postgresRateLimitStore struct {
+	db *db.Queries
+}
+
+func (s postgresRateLimitStore) take(ctx context.Context, key string, limit rateLimit) (float64, bool, error) {
+	bucket, err := s.db.TakeRateLimitToken(ctx, db.TakeRateLimitTokenParams{
+		Key:       key,
+		Burst:     limit.burst,
+		PerSecond: limit.perSecond,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return bucket.Tokens, bucket.Allowed, nil
+}
+
+func (s postgresRateLimitStore) prune(ctx context.Context, idle time.Duration) error {
+	return s.db.DeleteIdleRateLimitBuckets(ctx, int32(idle/time.Second))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+const minArticleLength = 250
+
+var errPageTooLarge = errors.New("page too large")
+
+var (
+	positiveHints = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|story|text|blog`)
+	negativeHints = regexp.MustCompile(`(?i)-ad-|advert|banner|breadcrumb|combx|comment|contact|cookie|foot|masthead|menu|meta|modal|nav|newsletter|outbrain|popup|promo|related|share|sidebar|social|sponsor|subscribe|tags|taboola|tool|widget`)
+)
+
+// boilerplateTags never hold the article body.
+var boilerplateTags = map[atom.Atom]bool{
+	atom.Aside:  true,
+	atom.Footer: true,
+	atom.Header: true,
+	atom.Nav:    true,
+}
+
+// fetchArticle downloads and parses the HTML page at pageURL, with the same
+// client and size limit used for feeds.
+func (f *feedFetcher) fetchArticle(pageURL string) (*html.Node, error) {
+	resp, err := f.client.Get(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+	if resp.ContentLength > f.maxBodySize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", errPageTooLarge, resp.ContentLength, f.maxBodySize)
+	}
+
+	body := &countingReader{r: io.LimitReader(resp.Body, f.maxBodySize+1)}
+	r, err := charset.NewReader(body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(r)
+	if body.n > f.maxBodySize {
+		return nil, fmt.Errorf("%w: body exceeds limit of %d bytes", errPageTooLarge, f.maxBodySize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// extractArticle finds the main article body in doc with a readability
+// style heuristic: paragraphs score their parent and grandparent by length
+// and commas, class and id names nudge the score, and link-heavy blocks are
+// penalized. It returns the sanitized body, or "" when nothing looks like
+// an article.
+func extractArticle(doc *html.Node, pageURL string) string {
+	removeBoilerplate(doc)
+
+	scores := make(map[*html.Node]float64)
+	var candidates []*html.Node
+	addScore := func(node *html.Node, score float64) {
+		if node == nil || node.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[node]; !ok {
+			scores[node] = initialScore(node)
+			candidates = append(candidates, node)
+		}
+		scores[node] += score
+	}
+
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch node.DataAtom {
+			case atom.P, atom.Pre, atom.Td:
+				text := nodeText(node)
+				length := utf8.RuneCountInString(text)
+				if length >= 25 {
+					score := 1 + float64(strings.Count(text, ","))
+					score += min(float64(length)/100, 3)
+					addScore(node.Parent, score)
+					if node.Parent != nil {
+						addScore(node.Parent.Parent, score/2)
+					}
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	var top *html.Node
+	for _, candidate := range candidates {
+		scores[candidate] *= 1 - linkDensity(candidate)
+		if top == nil || scores[candidate] > scores[top] {
+			top = candidate
+		}
+	}
+	if top == nil {
+		return ""
+	}
+
+	// Siblings that score well enough are likely split-out parts of the
+	// same article, such as a lead paragraph outside the main container.
+	threshold := max(10, scores[top]*0.2)
+	var sb strings.Builder
+	for node := top.Parent.FirstChild; node != nil; node = node.NextSibling {
+		score, scored := scores[node]
+		if node != top && (!scored || score < threshold) {
+			continue
+		}
+		if err := html.Render(&sb, node); err != nil {
+			return ""
+		}
+	}
+
+	article := sanitizeHTML(sb.String(), pageURL)
+	if utf8.RuneCountInString(htmlToText(article)) < minArticleLength {
+		return ""
+	}
+	return article
+}
+
+// removeBoilerplate drops scripts, navigation and blocks whose class or id
+// marks them as comments, sidebars, share bars and the like.
+func removeBoilerplate(node *html.Node) {
+	child := node.FirstChild
+	for child != nil {
+		next := child.NextSibling
+		if child.Type == html.CommentNode || child.Type == html.ElementNode && isBoilerplate(child) {
+			node.RemoveChild(child)
+		} else {
+			removeBoilerplate(child)
+		}
+		child = next
+	}
+}
+
+func isBoilerplate(node *html.Node) bool {
+	switch node.DataAtom {
+	case atom.Body, atom.Article, atom.Main:
+		return false
+	case atom.Form:
+		// Some sites wrap the whole page in a form.
+		return isUnlikelyCandidate(node)
+	}
+	return droppedTags[node.DataAtom] || boilerplateTags[node.DataAtom] || isUnlikelyCandidate(node)
+}
+
+func isUnlikelyCandidate(node *html.Node) bool {
+	hints := classAndID(node)
+	return negativeHints.MatchString(hints) && !positiveHints.MatchString(hints)
+}
+
+func initialScore(node *html.Node) float64 {
+	var score float64
+	switch node.DataAtom {
+	case atom.Article, atom.Main:
+		score = 10
+	case atom.Div:
+		score = 5
+	case atom.Blockquote, atom.Pre, atom.Td:
+		score = 3
+	case atom.Dl, atom.Form, atom.Li, atom.Ol, atom.Ul:
+		score = -3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		score = -5
+	}
+
+	hints := classAndID(node)
+	if positiveHints.MatchString(hints) {
+		score += 25
+	}
+	if negativeHints.MatchString(hints) {
+		score -= 25
+	}
+	return score
+}
+
+func classAndID(node *html.Node) string {
+	var hints []string
+	for _, attr := range node.Attr {
+		if attr.Key == "class" || attr.Key == "id" {
+			hints = append(hints, attr.Val)
+		}
+	}
+	return strings.Join(hints, " ")
+}
+
+// linkDensity is the share of node's text that sits inside links.
+func linkDensity(node *html.Node) float64 {
+	textLength := utf8.RuneCountInString(nodeText(node))
+	if textLength == 0 {
+		return 0
+	}
+	linkLength := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			linkLength += utf8.RuneCountInString(nodeText(n))
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return float64(linkLength) / float64(textLength)
+}
+
+// nodeText returns the whitespace-collapsed text inside node.
+func nodeText(node *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteByte(' ')
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
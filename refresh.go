@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const refreshQueueSize = 100
+
+// refreshRequest asks the scraper to scrape a feed right away, outside the
+// regular GetNextFeedsToFetch rotation.
+type refreshRequest struct {
+	feed db.Feed
+}
+
+// requestRefresh queues feed for an immediate scrape. The returned channel
+// receives the result once the scrape is done; false means the queue is full.
+// A feed already queued or being refreshed isn't queued again: the caller
+// gets the result of that refresh instead, so repeated requests for one
+// feed can't fill the queue.
+func (s *scraper) requestRefresh(feed db.Feed) (<-chan scrapeResult, bool) {
+	result := make(chan scrapeResult, 1)
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	if waiting, ok := s.pendingRefreshes[feed.ID]; ok {
+		s.pendingRefreshes[feed.ID] = append(waiting, result)
+		return result, true
+	}
+	select {
+	case s.refreshes <- refreshRequest{feed: feed}:
+		s.pendingRefreshes[feed.ID] = []chan scrapeResult{result}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// finishRefresh hands the result of refreshing a feed to everyone waiting
+// on it.
+func (s *scraper) finishRefresh(feedID uuid.UUID, res scrapeResult) {
+	s.refreshMu.Lock()
+	waiting := s.pendingRefreshes[feedID]
+	delete(s.pendingRefreshes, feedID)
+	s.refreshMu.Unlock()
+	for _, result := range waiting {
+		result <- res
+	}
+}
+
+// processRefreshes runs queued refreshes, at most s.concurrency at a time,
+// independently of the scrape cycle so they never wait for its ticker.
+func (s *scraper) processRefreshes(ctx context.Context) {
+	slots := make(chan struct{}, s.concurrency)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-s.refreshes:
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- struct{}{}:
+			}
+			go func() {
+				defer func() { <-slots }()
+				log.Printf("refreshing feed %s on request", req.feed.Name)
+				s.finishRefresh(req.feed.ID, s.scrapeFeed(ctx, req.feed))
+			}()
+		}
+	}
+}
@@ -2,49 +2,331 @@ package main
 
 import (
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
+const (
+	defaultMaxFeedSize  = 10 << 20
+	defaultMaxFeedItems = 100
+)
+
+var errFeedTooLarge = errors.New("feed too large")
+
 type RSSFeed struct {
 	Channel struct {
 		Title string `xml:"title"`
+		// AtomLinks holds atom:link elements, which decodeChannel keeps
+		// apart from the plain RSS link.
+		AtomLinks []RSSAtomLink `xml:"http://www.w3.org/2005/Atom link"`
 		Link  string `xml:"link"`
 		Description string `xml:"description"`
 		Language string `xml:"language"`
+		Base string `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
 		Item []RSSItem `xml:"item"`
 	} `xml:"channel"`
 }
 
 type RSSItem struct {
+	Base string `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
 	Title string `xml:"title"`
 	Link  string `xml:"link"`
 	Description string `xml:"description"`
 	PubDate string `xml:"pubDate"`
+	DCDate string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	ContentEncoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Author string `xml:"author"`
+	Creator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories []string `xml:"category"`
+	Comments string `xml:"comments"`
+	Source RSSSource `xml:"source"`
+	Enclosures []RSSEnclosure `xml:"enclosure"`
+	ItunesDuration string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	ItunesEpisode string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	ItunesSeason string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	ItunesImage RSSItunesImage `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	ItunesExplicit string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+	MediaContents []RSSMediaContent `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaThumbnails []RSSMediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	MediaGroups []RSSMediaGroup `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+type RSSAtomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type RSSSource struct {
+	URL  string `xml:"url,attr"`
+	Name string `xml:",chardata"`
+}
+
+// publishedAt returns the item's pubDate, falling back to dc:date which
+// RSS 1.0 and some RSS 2.0 feeds use instead.
+func (item RSSItem) publishedAt() string {
+	if item.PubDate != "" {
+		return item.PubDate
+	}
+	return item.DCDate
+}
+
+// contentBase returns the URL relative links in the item's HTML resolve
+// against: the xml:base in scope, else the item link, else the channel
+// link, else the feed URL itself.
+func (item RSSItem) contentBase(rssFeed RSSFeed, feedURL string) string {
+	base := feedURL
+	if rssFeed.Channel.Base != "" {
+		base = resolveURL(base, rssFeed.Channel.Base)
+	}
+	if item.Base != "" {
+		return resolveURL(base, item.Base)
+	}
+	if rssFeed.Channel.Base == "" {
+		if link := strings.TrimSpace(item.Link); link != "" {
+			return resolveURL(base, link)
+		}
+		if link := strings.TrimSpace(rssFeed.Channel.Link); link != "" {
+			return resolveURL(base, link)
+		}
+	}
+	return base
 }
 
-func urlToFeed(url string) (RSSFeed, error) {
-	httpClient := http.Client{
-		Timeout: time.Second * 10,
+// linkBase returns the URL relative item links resolve against: the
+// channel's xml:base, else the channel link, else the feed URL.
+func (rssFeed RSSFeed) linkBase(feedURL string) string {
+	if rssFeed.Channel.Base != "" {
+		return resolveURL(feedURL, rssFeed.Channel.Base)
 	}
+	if link := strings.TrimSpace(rssFeed.Channel.Link); link != "" {
+		return resolveURL(feedURL, link)
+	}
+	return feedURL
+}
+
+// webSubLinks returns the feed's WebSub hub and self (topic) URLs, if it
+// advertises them.
+func (rssFeed RSSFeed) webSubLinks(feedURL string) (hub, self string) {
+	for _, link := range rssFeed.Channel.AtomLinks {
+		href := strings.TrimSpace(link.Href)
+		if href == "" {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(link.Rel)) {
+		case "hub":
+			if hub == "" {
+				hub = resolveURL(feedURL, href)
+			}
+		case "self":
+			if self == "" {
+				self = resolveURL(feedURL, href)
+			}
+		}
+	}
+	return hub, self
+}
+
+// author prefers dc:creator, which holds a plain name, over author, which
+// RSS 2.0 defines as an email address.
+func (item RSSItem) author() string {
+	if creator := strings.TrimSpace(item.Creator); creator != "" {
+		return creator
+	}
+	return strings.TrimSpace(item.Author)
+}
 
-	resp, err := httpClient.Get(url)
+// categories returns the item's distinct, non-empty category names.
+func (item RSSItem) categories() []string {
+	seen := make(map[string]bool, len(item.Categories))
+	categories := make([]string, 0, len(item.Categories))
+	for _, category := range item.Categories {
+		category = strings.TrimSpace(category)
+		if category == "" || seen[category] {
+			continue
+		}
+		seen[category] = true
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+type feedFetcher struct {
+	client      *http.Client
+	maxBodySize int64
+	maxItems    int
+}
+
+func newFeedFetcher(guard *networkGuard, maxBodySize int64, maxItems int) *feedFetcher {
+	return &feedFetcher{
+		client:      guard.httpClient(time.Second * 10),
+		maxBodySize: maxBodySize,
+		maxItems:    maxItems,
+	}
+}
+
+// fetchStats describes the HTTP side of a feed fetch. statusCode is 0 when
+// no response was received.
+type fetchStats struct {
+	statusCode int
+	bytes      int64
+}
+
+func (f *feedFetcher) urlToFeed(url string) (RSSFeed, fetchStats, error) {
+	stats := fetchStats{}
+	resp, err := f.client.Get(url)
 	if err != nil {
-		return RSSFeed{}, err
+		return RSSFeed{}, stats, err
 	}
 	defer resp.Body.Close()
+	stats.statusCode = resp.StatusCode
 
-	data, err := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return RSSFeed{}, stats, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	if resp.ContentLength > f.maxBodySize {
+		return RSSFeed{}, stats, fmt.Errorf("%w: %d bytes exceeds limit of %d", errFeedTooLarge, resp.ContentLength, f.maxBodySize)
+	}
+
+	body := &countingReader{r: resp.Body}
+	rssFeed, err := f.parseFeed(body, resp.Header.Get("Content-Type"))
+	stats.bytes = body.n
+	return rssFeed, stats, err
+}
+
+// parseFeed decodes a feed body, whether fetched or pushed, enforcing the
+// size and item limits.
+func (f *feedFetcher) parseFeed(r io.Reader, contentType string) (RSSFeed, error) {
+	// Read one byte past the limit so an oversized body can be told apart
+	// from one that is exactly maxBodySize long.
+	body := &countingReader{r: io.LimitReader(r, f.maxBodySize+1)}
+
+	decoder, err := newFeedDecoder(body, contentType)
 	if err != nil {
 		return RSSFeed{}, err
 	}
 
-	var rssFeed RSSFeed
-	err = xml.Unmarshal(data, &rssFeed)
+	rssFeed, err := decodeFeed(decoder, f.maxItems)
+	if body.n > f.maxBodySize {
+		return RSSFeed{}, fmt.Errorf("%w: body exceeds limit of %d bytes", errFeedTooLarge, f.maxBodySize)
+	}
 	if err != nil {
 		return RSSFeed{}, err
 	}
 
 	return rssFeed, nil
 }
+
+const (
+	xmlNamespace  = "http://www.w3.org/XML/1998/namespace"
+	atomNamespace = "http://www.w3.org/2005/Atom"
+)
+
+// decodeFeed reads the channel of the feed's root element. Items are
+// decoded one at a time and reading stops after maxItems of them, so the
+// cap bounds the work done on a feed and not just what is kept of it.
+// Channel elements that come after the last item read are not seen.
+func decodeFeed(decoder *xml.Decoder, maxItems int) (RSSFeed, error) {
+	var rssFeed RSSFeed
+	// Find the root element, skipping the declaration, comments and
+	// doctype before it.
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return RSSFeed{}, err
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			break
+		}
+	}
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return RSSFeed{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "channel" {
+				if err := decoder.Skip(); err != nil {
+					return RSSFeed{}, err
+				}
+				continue
+			}
+			if err := decodeChannel(decoder, t, &rssFeed, maxItems); err != nil {
+				return RSSFeed{}, err
+			}
+			return rssFeed, nil
+		case xml.EndElement:
+			return rssFeed, nil
+		}
+	}
+}
+
+// decodeChannel decodes the children of the channel start element into
+// rssFeed, stopping at the first item past maxItems.
+func decodeChannel(decoder *xml.Decoder, start xml.StartElement, rssFeed *RSSFeed, maxItems int) error {
+	channel := &rssFeed.Channel
+	for _, attr := range start.Attr {
+		if attr.Name.Space == xmlNamespace && attr.Name.Local == "base" {
+			channel.Base = attr.Value
+		}
+	}
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case isRSSElement(t.Name, "item"):
+				if maxItems > 0 && len(channel.Item) >= maxItems {
+					return nil
+				}
+				var item RSSItem
+				err = decoder.DecodeElement(&item, &t)
+				channel.Item = append(channel.Item, item)
+			case t.Name.Space == atomNamespace && t.Name.Local == "link":
+				var link RSSAtomLink
+				err = decoder.DecodeElement(&link, &t)
+				channel.AtomLinks = append(channel.AtomLinks, link)
+			case isRSSElement(t.Name, "title"):
+				err = decoder.DecodeElement(&channel.Title, &t)
+			case isRSSElement(t.Name, "link"):
+				err = decoder.DecodeElement(&channel.Link, &t)
+			case isRSSElement(t.Name, "description"):
+				err = decoder.DecodeElement(&channel.Description, &t)
+			case isRSSElement(t.Name, "language"):
+				err = decoder.DecodeElement(&channel.Language, &t)
+			default:
+				err = decoder.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// isRSSElement reports whether name is the RSS element local. RSS elements
+// have no namespace, unlike extensions of the same name such as atom:link
+// or media:title.
+func isRSSElement(name xml.Name, local string) bool {
+	return name.Space == "" && name.Local == local
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedTags maps each tag kept by sanitizeHTML to the attributes it may
+// carry. Tags not listed are unwrapped, keeping their children.
+var allowedTags = map[atom.Atom][]string{
+	atom.A:          {"href", "title"},
+	atom.Abbr:       {"title"},
+	atom.Audio:      {"src", "controls"},
+	atom.B:          nil,
+	atom.Blockquote: {"cite"},
+	atom.Br:         nil,
+	atom.Caption:    nil,
+	atom.Cite:       nil,
+	atom.Code:       nil,
+	atom.Dd:         nil,
+	atom.Del:        nil,
+	atom.Details:    nil,
+	atom.Div:        nil,
+	atom.Dl:         nil,
+	atom.Dt:         nil,
+	atom.Em:         nil,
+	atom.Figcaption: nil,
+	atom.Figure:     nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.H5:         nil,
+	atom.H6:         nil,
+	atom.Hr:         nil,
+	atom.I:          nil,
+	atom.Img:        {"src", "alt", "title", "width", "height"},
+	atom.Ins:        nil,
+	atom.Kbd:        nil,
+	atom.Li:         nil,
+	atom.Mark:       nil,
+	atom.Ol:         {"start"},
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.Q:          {"cite"},
+	atom.S:          nil,
+	atom.Small:      nil,
+	atom.Source:     {"src", "type"},
+	atom.Span:       nil,
+	atom.Strong:     nil,
+	atom.Sub:        nil,
+	atom.Summary:    nil,
+	atom.Sup:        nil,
+	atom.Table:      nil,
+	atom.Tbody:      nil,
+	atom.Td:         {"colspan", "rowspan"},
+	atom.Tfoot:      nil,
+	atom.Th:         {"colspan", "rowspan", "scope"},
+	atom.Thead:      nil,
+	atom.Time:       {"datetime"},
+	atom.Tr:         nil,
+	atom.U:          nil,
+	atom.Ul:         nil,
+	atom.Video:      {"src", "poster", "controls", "width", "height"},
+}
+
+// droppedTags are removed together with everything inside them.
+var droppedTags = map[atom.Atom]bool{
+	atom.Applet:   true,
+	atom.Base:     true,
+	atom.Button:   true,
+	atom.Embed:    true,
+	atom.Form:     true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Head:     true,
+	atom.Iframe:   true,
+	atom.Input:    true,
+	atom.Link:     true,
+	atom.Math:     true,
+	atom.Meta:     true,
+	atom.Noscript: true,
+	atom.Object:   true,
+	atom.Script:   true,
+	atom.Select:   true,
+	atom.Style:    true,
+	atom.Svg:      true,
+	atom.Template: true,
+	atom.Textarea: true,
+	atom.Title:    true,
+}
+
+var urlAttributes = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"cite":   true,
+	"poster": true,
+}
+
+// sanitizeHTML reduces an item's HTML to a safe subset: only allowlisted
+// tags and attributes survive, relative URLs are resolved against base,
+// links get rel="noopener noreferrer" and tracking pixels are removed.
+func sanitizeHTML(fragment, base string) string {
+	if strings.TrimSpace(fragment) == "" {
+		return ""
+	}
+	fragmentContext := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), fragmentContext)
+	if err != nil {
+		return html.EscapeString(fragment)
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil || baseURL.Scheme == "" {
+		baseURL = nil
+	}
+
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, node := range nodes {
+		root.AppendChild(node)
+	}
+	sanitizeChildren(root, baseURL)
+
+	var sb strings.Builder
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if err := html.Render(&sb, child); err != nil {
+			return ""
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func sanitizeChildren(parent *html.Node, baseURL *url.URL) {
+	child := parent.FirstChild
+	for child != nil {
+		next := child.NextSibling
+		switch child.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			sanitizeElement(parent, child, baseURL)
+		default:
+			parent.RemoveChild(child)
+		}
+		child = next
+	}
+}
+
+func sanitizeElement(parent, node *html.Node, baseURL *url.URL) {
+	if droppedTags[node.DataAtom] || isTrackingPixel(node) {
+		parent.RemoveChild(node)
+		return
+	}
+
+	allowedAttrs, ok := allowedTags[node.DataAtom]
+	if !ok {
+		// Unwrap: sanitize the children, then move them up in place of node.
+		sanitizeChildren(node, baseURL)
+		for child := node.FirstChild; child != nil; child = node.FirstChild {
+			node.RemoveChild(child)
+			parent.InsertBefore(child, node)
+		}
+		parent.RemoveChild(node)
+		return
+	}
+
+	attrs := make([]html.Attribute, 0, len(node.Attr))
+	for _, attr := range node.Attr {
+		if attr.Namespace != "" || !slices.Contains(allowedAttrs, attr.Key) {
+			continue
+		}
+		if urlAttributes[attr.Key] {
+			safeURL, ok := sanitizeURL(attr.Val, baseURL, attr.Key == "href")
+			if !ok {
+				continue
+			}
+			attr.Val = safeURL
+		}
+		attrs = append(attrs, attr)
+	}
+	node.Attr = attrs
+
+	if node.DataAtom == atom.A {
+		node.Attr = append(node.Attr, html.Attribute{Key: "rel", Val: "noopener noreferrer"})
+	}
+	if node.DataAtom == atom.Img && !hasAttr(node, "src") {
+		parent.RemoveChild(node)
+		return
+	}
+
+	sanitizeChildren(node, baseURL)
+}
+
+// sanitizeURL resolves a link against baseURL and accepts only web URLs,
+// plus mailto: for hrefs.
+func sanitizeURL(raw string, baseURL *url.URL, allowMailto bool) (string, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", false
+	}
+	if baseURL != nil {
+		parsed = baseURL.ResolveReference(parsed)
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return parsed.String(), true
+	case "mailto":
+		return parsed.String(), allowMailto
+	case "":
+		// Still relative without a base: keep paths, reject anything odd.
+		return parsed.String(), parsed.Opaque == ""
+	}
+	return "", false
+}
+
+func isTrackingPixel(node *html.Node) bool {
+	if node.DataAtom != atom.Img {
+		return false
+	}
+	width, height := "", ""
+	for _, attr := range node.Attr {
+		switch attr.Key {
+		case "width":
+			width = strings.TrimSuffix(strings.TrimSpace(attr.Val), "px")
+		case "height":
+			height = strings.TrimSuffix(strings.TrimSpace(attr.Val), "px")
+		}
+	}
+	tiny := func(v string) bool { return v == "0" || v == "1" }
+	return tiny(width) && tiny(height)
+}
+
+func hasAttr(node *html.Node, key string) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"strings"
 	"sync"
@@ -12,104 +14,377 @@ import (
 	"github.com/viniciuspra/rssagg/internal/db"
 )
 
-func startScraping(ctx context.Context, dbQ *db.Queries, concurrency int, timeBtwReq time.Duration) {
-	log.Printf("starting scraping on %v goroutines every %v", concurrency, timeBtwReq)
+type scraper struct {
+	db          *db.Queries
+	fetcher     *feedFetcher
+	clusterer   *postClusterer
+	websub      *webSubClient
+	refreshes   chan refreshRequest
+	concurrency int
+	interval    time.Duration
+	// fetchRetentionDays is how long fetch history is kept; 0 keeps it forever.
+	fetchRetentionDays int
+	// refreshMu guards pendingRefreshes, the callers waiting on each
+	// feed that is queued or being refreshed.
+	refreshMu        sync.Mutex
+	pendingRefreshes map[uuid.UUID][]chan scrapeResult
+}
+
+// scrapeResult is the outcome of scraping one feed.
+type scrapeResult struct {
+	stats        fetchStats
+	itemsSeen    int
+	newPosts     int
+	updatedPosts int
+	err          error
+}
+
+func (s *scraper) startScraping(ctx context.Context) {
+	log.Printf("starting scraping on %v goroutines every %v", s.concurrency, s.interval)
+
+	sanitizeStoredPosts(ctx, s.db)
+	canonicalizeStoredURLs(ctx, s.db)
 
-	ticker := time.NewTicker(timeBtwReq)
+	go s.processRefreshes(ctx)
+
+	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
-	runScrapeCycle(ctx, dbQ, concurrency)
+	pruneTicker := time.NewTicker(fetchPruneInterval)
+	defer pruneTicker.Stop()
+	s.pruneFeedFetches(ctx)
+	s.runScrapeCycle(ctx)
 	for {
 		select {
 			case <-ctx.Done():
 				log.Println(ctx.Err())
 				return
 			case <-ticker.C:
-				runScrapeCycle(ctx, dbQ, concurrency)
+				s.runScrapeCycle(ctx)
+			case <-pruneTicker.C:
+				s.pruneFeedFetches(ctx)
 		}
 	}
 }
 
-func runScrapeCycle(ctx context.Context, dbQ *db.Queries, concurrency int) {
+func (s *scraper) runScrapeCycle(ctx context.Context) {
 	wg := &sync.WaitGroup{}
-	feeds, err := dbQ.GetNextFeedsToFetch(ctx, int32(concurrency))
+	params := db.GetNextFeedsToFetchParams{Limit: int32(s.concurrency)}
+	if s.websub != nil {
+		params.RenewWithinSeconds = int32(webSubRenewWithin / time.Second)
+		params.PushedPollSeconds = int32(s.websub.pollInterval / time.Second)
+	}
+	feeds, err := s.db.GetNextFeedsToFetch(ctx, params)
 	if err != nil {
 		log.Println("error fetching next feeds to fetch:", err)
 		return
 	}
 	for _, feed := range feeds {
 		wg.Add(1)
-		go scrapeFeed(ctx, wg, dbQ, feed)
+		go func() {
+			defer wg.Done()
+			s.scrapeFeed(ctx, feed)
+		}()
 	}
 	wg.Wait()
 }
 
-func scrapeFeed(ctx context.Context, wg *sync.WaitGroup, dbQ *db.Queries, feed db.Feed) {
-	defer wg.Done()
+// scrapeFeed fetches and ingests feed, and records the attempt in its
+// fetch history.
+func (s *scraper) scrapeFeed(ctx context.Context, feed db.Feed) scrapeResult {
+	startedAt := time.Now().UTC()
+	result := s.fetchAndIngest(ctx, feed)
+	s.recordFeedFetch(ctx, feed, startedAt, result)
+	return result
+}
 
-	err := dbQ.MarkFeedAsFetched(ctx, feed.ID)
+func (s *scraper) fetchAndIngest(ctx context.Context, feed db.Feed) scrapeResult {
+	err := s.db.MarkFeedAsFetched(ctx, feed.ID)
 	if err != nil {
 		log.Println("error marking feed as fetched:", err)
-		return
+		return scrapeResult{err: err}
 	}
 
-	rssFeed, err := urlToFeed(feed.Url)
+	rssFeed, stats, err := s.fetcher.urlToFeed(feed.OriginalUrl)
+	result := scrapeResult{stats: stats}
 	if err != nil {
-		log.Println("error fetching next feeds to fetch:", err)
-		return
+		log.Printf("error fetching feed %s: %v", feed.Name, err)
+		result.err = err
+		return result
+	}
+
+	result.itemsSeen = len(rssFeed.Channel.Item)
+	result.newPosts, result.updatedPosts = s.ingestFeed(ctx, feed, rssFeed)
+
+	if s.websub != nil {
+		err = s.websub.ensureSubscribed(ctx, feed, rssFeed)
+		if err != nil {
+			log.Printf("error subscribing to WebSub hub of feed %s: %v", feed.Name, err)
+		}
 	}
+	return result
+}
 
+// ingestFeed stores the items of a feed document, whether it was polled by
+// scrapeFeed or pushed by a WebSub hub, and reports how many posts were
+// new and how many were updated.
+func (s *scraper) ingestFeed(ctx context.Context, feed db.Feed, rssFeed RSSFeed) (newPosts, updatedPosts int) {
 	for _, item := range rssFeed.Channel.Item {
 		select {
 	    case <-ctx.Done():
-	        return
+	        return newPosts, updatedPosts
 	    default:
     }
-		description := sql.NullString{}
-		if item.Description != "" {
-			description.String = item.Description
-			description.Valid = true
+		linkBase := rssFeed.linkBase(feed.OriginalUrl)
+		postURL, err := canonicalizeURL(item.Link, linkBase)
+		if err != nil {
+			log.Printf("skipping post %v without a usable link: %v", item.Title, err)
+			continue
 		}
 
-		pubDate, err := parsePubDate(item.PubDate)
+		pubDate, err := parsePubDate(item.publishedAt())
 		if err != nil {
-			log.Println("error parsing date:", err)
-			continue
+			if !errors.Is(err, errMissingPubDate) {
+				log.Printf("error parsing date of post %v, using discovery time: %v", item.Title, err)
+			}
+			pubDate = time.Now().UTC()
 		}
 
-		_, err = dbQ.CreatePost(ctx, db.CreatePostParams{
-			ID: uuid.New(),
+		base := item.contentBase(rssFeed, feed.OriginalUrl)
+		description := sanitizeHTML(item.Description, base)
+		content := sanitizeHTML(item.ContentEncoded, base)
+		summary := summarizePost(description, content)
+
+		postID := uuid.New()
+		text := htmlToText(content)
+		if text == "" {
+			text = htmlToText(description)
+		}
+		fingerprint := sql.NullInt64{}
+		fingerprint.Int64, fingerprint.Valid = simhash(item.Title + " " + text)
+		post, err := s.db.UpsertPost(ctx, db.UpsertPostParams{
+			ID: postID,
 			FeedID: feed.ID,
 			Title: item.Title,
-			Description: description,
+			Description: toNullString(description),
 			PublishedAt: pubDate,
-			Url: item.Link,
+			Url: postURL,
+			Content: toNullString(content),
+			Author: toNullString(item.author()),
+			CommentsUrl: toNullString(strings.TrimSpace(item.Comments)),
+			SourceName: toNullString(strings.TrimSpace(item.Source.Name)),
+			SourceUrl: toNullString(strings.TrimSpace(item.Source.URL)),
+			ItunesEpisode: parseNullInt32(item.ItunesEpisode),
+			ItunesSeason: parseNullInt32(item.ItunesSeason),
+			ItunesImage: toNullString(mediaURL(item.ItunesImage.Href, base)),
+			ItunesExplicit: parseItunesExplicit(item.ItunesExplicit),
+			ThumbnailUrl: toNullString(item.thumbnailURL(base)),
+			DescriptionRaw: toNullString(item.Description),
+			ContentRaw: toNullString(item.ContentEncoded),
+			Summary: summary.Excerpt,
+			WordCount: summary.WordCount,
+			ReadingTimeMinutes: summary.ReadingTimeMinutes,
+			OriginalUrl: resolveURL(linkBase, strings.TrimSpace(item.Link)),
+			Fingerprint: fingerprint,
+			// New posts start out as their own cluster and are matched
+			// against other feeds once inserted, below.
+			ClusterID: postID,
 		})
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
 		if err != nil {
-			if strings.Contains(err.Error(), "duplicate key") {
+			log.Printf("error creating post %v with err: %v", item.Title, err)
+			continue
+		}
+		if post.Inserted {
+			newPosts++
+			s.clusterer.assignCluster(ctx, s.db, post.ID, feed.ID, fingerprint, pubDate)
+		} else {
+			updatedPosts++
+		}
+
+		// Categories dropped from the item are dropped from the post too.
+		err = s.db.SetPostCategories(ctx, db.SetPostCategoriesParams{
+			PostID: post.ID,
+			Names: item.categories(),
+		})
+		if err != nil {
+			log.Printf("error setting categories of post %v: %v", item.Title, err)
+		}
+
+		for _, enclosure := range item.Enclosures {
+			enclosureURL := mediaURL(enclosure.URL, base)
+			if enclosureURL == "" {
 				continue
 			}
-			log.Printf("error creating post %v with err: %v", item.Title, err)
+			err = s.db.CreatePostEnclosure(ctx, db.CreatePostEnclosureParams{
+				ID: uuid.New(),
+				PostID: post.ID,
+				Url: enclosureURL,
+				MimeType: strings.ToLower(strings.TrimSpace(enclosure.Type)),
+				Length: parseNullInt64(enclosure.Length),
+				DurationSeconds: parseItunesDuration(item.ItunesDuration),
+			})
+			if err != nil {
+				log.Printf("error creating enclosure %v for post %v: %v", enclosureURL, item.Title, err)
+			}
 		}
 	}
 
-	log.Printf("feed %s collected, %v posts found", feed.Name, len(rssFeed.Channel.Item))
+	log.Printf("feed %s collected, %v posts found, %v new, %v updated", feed.Name, len(rssFeed.Channel.Item), newPosts, updatedPosts)
+	return newPosts, updatedPosts
 }
 
-func parsePubDate(pubDate string) (time.Time, error) {
-	layouts := []string{
-		time.RFC1123,
-		time.RFC1123Z,
+// sanitizeStoredPosts sanitizes posts stored before HTML sanitization was
+// part of ingest, keeping their original markup in the raw columns.
+func sanitizeStoredPosts(ctx context.Context, dbQ *db.Queries) {
+	const batchSize = 100
+	sanitized := 0
+	for {
+		posts, err := dbQ.GetUnsanitizedPosts(ctx, batchSize)
+		if err != nil {
+			log.Println("error getting unsanitized posts:", err)
+			return
+		}
+		if len(posts) == 0 {
+			break
+		}
+		for _, post := range posts {
+			descriptionRaw := post.DescriptionRaw
+			if !descriptionRaw.Valid {
+				descriptionRaw = post.Description
+			}
+			contentRaw := post.ContentRaw
+			if !contentRaw.Valid {
+				contentRaw = post.Content
+			}
+			err := dbQ.UpdatePostSanitizedContent(ctx, db.UpdatePostSanitizedContentParams{
+				ID: post.ID,
+				Description: toNullString(sanitizeHTML(descriptionRaw.String, post.Url)),
+				DescriptionRaw: descriptionRaw,
+				Content: toNullString(sanitizeHTML(contentRaw.String, post.Url)),
+				ContentRaw: contentRaw,
+			})
+			if err != nil {
+				log.Printf("error sanitizing post %v: %v", post.ID, err)
+				return
+			}
+			sanitized++
+		}
+	}
+	if sanitized > 0 {
+		log.Printf("sanitized %v stored posts", sanitized)
+	}
+}
+
+// canonicalizeStoredURLs rewrites the URLs of feeds and posts stored before
+// URLs were canonicalized, so that scrapes match them up with what they
+// fetch instead of storing them again. A feed whose canonical URL another
+// feed already has is merged into it; such a post is dropped in favor of
+// the other.
+func canonicalizeStoredURLs(ctx context.Context, dbQ *db.Queries) {
+	if err := canonicalizeStoredFeedURLs(ctx, dbQ); err != nil {
+		log.Println("error canonicalizing stored feed URLs:", err)
+		return
+	}
+	if err := canonicalizeStoredPostURLs(ctx, dbQ); err != nil {
+		log.Println("error canonicalizing stored post URLs:", err)
+	}
+}
+
+func canonicalizeStoredFeedURLs(ctx context.Context, dbQ *db.Queries) error {
+	const batchSize = 100
+	merged := 0
+	for {
+		feeds, err := dbQ.GetLegacyFeedUrls(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(feeds) == 0 {
+			break
+		}
+		for _, feed := range feeds {
+			feedURL, err := canonicalizeURL(feed.Url, "")
+			if err == nil && feedURL != feed.Url {
+				// The steps can all be run again, so a failure part way
+				// through is finished on the next start.
+				other, err := dbQ.GetFeedByUrl(ctx, feedURL)
+				switch {
+				case err == nil:
+					err = mergeFeed(ctx, dbQ, feed.ID, other.ID)
+					merged++
+				case errors.Is(err, sql.ErrNoRows):
+					err = dbQ.SetFeedUrl(ctx, db.SetFeedUrlParams{ID: feed.ID, Url: feedURL})
+				}
+				if err != nil {
+					return fmt.Errorf("feed %v: %w", feed.ID, err)
+				}
+			}
+			err = dbQ.DeleteLegacyFeedUrl(ctx, feed.ID)
+			if err != nil {
+				return err
+			}
+		}
 	}
+	if merged > 0 {
+		log.Printf("merged %v stored feeds into feeds with the same canonical URL", merged)
+	}
+	return nil
+}
 
-	var parsedDate time.Time
-	var err error
+// mergeFeed moves the follows and posts of one feed to another and deletes
+// it.
+func mergeFeed(ctx context.Context, dbQ *db.Queries, fromID, intoID uuid.UUID) error {
+	err := dbQ.MergeFeedFollows(ctx, db.MergeFeedFollowsParams{IntoFeedID: intoID, FromFeedID: fromID})
+	if err != nil {
+		return err
+	}
+	err = dbQ.MergeFeedPosts(ctx, db.MergeFeedPostsParams{IntoFeedID: intoID, FromFeedID: fromID})
+	if err != nil {
+		return err
+	}
+	_, err = dbQ.DeleteFeed(ctx, fromID)
+	return err
+}
 
-	for _, layout := range layouts {
-		parsedDate, err = time.Parse(layout, pubDate)
-		if err == nil {
-			return parsedDate, nil
+func canonicalizeStoredPostURLs(ctx context.Context, dbQ *db.Queries) error {
+	const batchSize = 100
+	dropped := 0
+	for {
+		posts, err := dbQ.GetLegacyPostUrls(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			break
 		}
+		for _, post := range posts {
+			postURL, err := canonicalizeURL(post.Url, "")
+			if err == nil && postURL != post.Url {
+				updated, err := dbQ.SetPostUrl(ctx, db.SetPostUrlParams{Url: postURL, ID: post.ID})
+				if err == nil && updated == 0 {
+					err = dbQ.DeletePost(ctx, post.ID)
+					dropped++
+				}
+				if err != nil {
+					return fmt.Errorf("post %v: %w", post.ID, err)
+				}
+			}
+			err = dbQ.DeleteLegacyPostUrl(ctx, post.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if dropped > 0 {
+		log.Printf("dropped %v stored posts already stored under their canonical URL", dropped)
 	}
+	return nil
+}
 
-	return time.Time{}, err
+func toNullString(s string) sql.NullString {
+	return sql.NullString{
+		String: s,
+		Valid: s != "",
+	}
 }
@@ -0,0 +1,25 @@
+-- +goose Up
+
+ALTER TABLE posts
+ADD COLUMN content TEXT,
+ADD COLUMN author TEXT,
+ADD COLUMN comments_url TEXT,
+ADD COLUMN source_name TEXT,
+ADD COLUMN source_url TEXT;
+
+CREATE TABLE post_categories (
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    PRIMARY KEY(post_id, name)
+);
+
+-- +goose Down
+
+DROP TABLE post_categories;
+
+ALTER TABLE posts
+DROP COLUMN content,
+DROP COLUMN author,
+DROP COLUMN comments_url,
+DROP COLUMN source_name,
+DROP COLUMN source_url;
@@ -0,0 +1,30 @@
+-- +goose Up
+
+ALTER TABLE posts
+ADD COLUMN itunes_episode INT,
+ADD COLUMN itunes_season INT,
+ADD COLUMN itunes_image TEXT,
+ADD COLUMN itunes_explicit BOOLEAN;
+
+CREATE TABLE post_enclosures (
+    id UUID PRIMARY KEY,
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    length BIGINT,
+    duration_seconds INT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE(post_id, url)
+);
+
+CREATE INDEX post_enclosures_mime_type_idx ON post_enclosures (mime_type);
+
+-- +goose Down
+
+DROP TABLE post_enclosures;
+
+ALTER TABLE posts
+DROP COLUMN itunes_episode,
+DROP COLUMN itunes_season,
+DROP COLUMN itunes_image,
+DROP COLUMN itunes_explicit;
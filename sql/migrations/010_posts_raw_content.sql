@@ -0,0 +1,11 @@
+-- +goose Up
+
+ALTER TABLE posts
+ADD COLUMN description_raw TEXT,
+ADD COLUMN content_raw TEXT;
+
+-- +goose Down
+
+ALTER TABLE posts
+DROP COLUMN description_raw,
+DROP COLUMN content_raw;
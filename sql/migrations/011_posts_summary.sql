@@ -0,0 +1,13 @@
+-- +goose Up
+
+ALTER TABLE posts
+ADD COLUMN summary TEXT,
+ADD COLUMN word_count INT,
+ADD COLUMN reading_time_minutes INT;
+
+-- +goose Down
+
+ALTER TABLE posts
+DROP COLUMN summary,
+DROP COLUMN word_count,
+DROP COLUMN reading_time_minutes;
@@ -0,0 +1,15 @@
+-- +goose Up
+
+ALTER TABLE posts ADD COLUMN original_url TEXT;
+UPDATE posts SET original_url = url;
+ALTER TABLE posts ALTER COLUMN original_url SET NOT NULL;
+
+ALTER TABLE feeds ADD COLUMN original_url TEXT;
+UPDATE feeds SET original_url = url;
+ALTER TABLE feeds ALTER COLUMN original_url SET NOT NULL;
+
+-- +goose Down
+
+ALTER TABLE feeds DROP COLUMN original_url;
+
+ALTER TABLE posts DROP COLUMN original_url;
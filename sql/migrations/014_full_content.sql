@@ -0,0 +1,17 @@
+-- +goose Up
+
+ALTER TABLE feeds
+ADD COLUMN extract_full_content_since TIMESTAMP;
+
+ALTER TABLE posts
+ADD COLUMN full_content TEXT,
+ADD COLUMN full_content_fetched_at TIMESTAMP;
+
+-- +goose Down
+
+ALTER TABLE posts
+DROP COLUMN full_content,
+DROP COLUMN full_content_fetched_at;
+
+ALTER TABLE feeds
+DROP COLUMN extract_full_content_since;
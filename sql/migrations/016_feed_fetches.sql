@@ -0,0 +1,22 @@
+-- +goose Up
+
+CREATE TABLE feed_fetches (
+    id UUID PRIMARY KEY,
+    feed_id UUID NOT NULL REFERENCES feeds(id) ON DELETE CASCADE,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    http_status INT,
+    bytes BIGINT,
+    duration_ms INT NOT NULL,
+    items_seen INT NOT NULL,
+    posts_inserted INT NOT NULL,
+    posts_updated INT NOT NULL,
+    error TEXT
+);
+
+CREATE INDEX feed_fetches_feed_id_started_at_idx ON feed_fetches (feed_id, started_at DESC);
+CREATE INDEX feed_fetches_started_at_idx ON feed_fetches (started_at);
+
+-- +goose Down
+
+DROP TABLE feed_fetches;
@@ -0,0 +1,30 @@
+-- +goose Up
+
+CREATE TABLE api_keys (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    key_hash TEXT UNIQUE NOT NULL,
+    prefix TEXT NOT NULL,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
+
+INSERT INTO api_keys (id, user_id, name, key_hash, prefix)
+SELECT gen_random_uuid(), id, 'default', encode(sha256(api_key::bytea), 'hex'), left(api_key, 8)
+FROM users;
+
+ALTER TABLE users DROP COLUMN api_key;
+
+-- +goose Down
+
+-- Only hashes are stored, so the old keys can't be restored: every user
+-- gets a new random key.
+ALTER TABLE users ADD COLUMN api_key VARCHAR(64) UNIQUE NOT NULL DEFAULT (
+  encode(sha256(random()::text::bytea), 'hex')
+);
+
+DROP TABLE api_keys;
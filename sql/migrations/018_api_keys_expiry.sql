@@ -0,0 +1,7 @@
+-- +goose Up
+
+ALTER TABLE api_keys ADD COLUMN expires_at TIMESTAMP;
+
+-- +goose Down
+
+ALTER TABLE api_keys DROP COLUMN expires_at;
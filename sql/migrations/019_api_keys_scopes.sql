@@ -0,0 +1,13 @@
+-- +goose Up
+
+ALTER TABLE api_keys ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}';
+
+-- Keys so far granted full access, which admin access is not part of.
+UPDATE api_keys SET scopes = ARRAY[
+    'user:read', 'keys:read', 'keys:write', 'feeds:read', 'feeds:write',
+    'follows:read', 'follows:write', 'posts:read'
+];
+
+-- +goose Down
+
+ALTER TABLE api_keys DROP COLUMN scopes;
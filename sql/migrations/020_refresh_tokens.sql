@@ -0,0 +1,19 @@
+-- +goose Up
+
+CREATE TABLE refresh_tokens (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- The API key the session was started with, if any; revoking the key
+    -- ends the session.
+    api_key_id UUID REFERENCES api_keys(id) ON DELETE CASCADE,
+    token_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+
+DROP TABLE refresh_tokens;
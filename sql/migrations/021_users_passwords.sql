@@ -0,0 +1,22 @@
+-- +goose Up
+
+-- Emails are stored lowercased, so a plain unique constraint is enough.
+ALTER TABLE users ADD COLUMN email TEXT UNIQUE;
+ALTER TABLE users ADD COLUMN password_hash TEXT;
+
+CREATE TABLE password_resets (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
+
+-- +goose Down
+
+DROP TABLE password_resets;
+
+ALTER TABLE users DROP COLUMN password_hash;
+ALTER TABLE users DROP COLUMN email;
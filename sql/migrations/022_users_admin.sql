@@ -0,0 +1,9 @@
+-- +goose Up
+
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP;
+
+-- +goose Down
+
+ALTER TABLE users DROP COLUMN disabled_at;
+ALTER TABLE users DROP COLUMN is_admin;
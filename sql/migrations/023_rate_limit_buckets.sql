@@ -0,0 +1,14 @@
+-- +goose Up
+
+-- Buckets are cheap to lose (they just start full again), so the table
+-- skips the write-ahead log.
+CREATE UNLOGGED TABLE rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+
+DROP TABLE rate_limit_buckets;
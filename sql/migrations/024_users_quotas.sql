@@ -0,0 +1,11 @@
+-- +goose Up
+
+-- Per-user overrides of MAX_FEEDS_PER_USER and MAX_FOLLOWS_PER_USER; NULL
+-- means the default applies.
+ALTER TABLE users ADD COLUMN max_feeds INT;
+ALTER TABLE users ADD COLUMN max_follows INT;
+
+-- +goose Down
+
+ALTER TABLE users DROP COLUMN max_follows;
+ALTER TABLE users DROP COLUMN max_feeds;
@@ -0,0 +1,16 @@
+-- +goose Up
+
+-- Defaults for GET /posts. A NULL page_size means the server default;
+-- display_preferences is validated by the API before it is stored.
+ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN page_size INT;
+ALTER TABLE users ADD COLUMN post_sort TEXT NOT NULL DEFAULT 'newest'
+    CHECK (post_sort IN ('newest', 'oldest'));
+ALTER TABLE users ADD COLUMN display_preferences JSONB NOT NULL DEFAULT '{}';
+
+-- +goose Down
+
+ALTER TABLE users DROP COLUMN display_preferences;
+ALTER TABLE users DROP COLUMN post_sort;
+ALTER TABLE users DROP COLUMN page_size;
+ALTER TABLE users DROP COLUMN timezone;
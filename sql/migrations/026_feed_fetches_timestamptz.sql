@@ -0,0 +1,14 @@
+-- +goose Up
+
+-- Fetch times are written by the application and pruned against NOW(), so
+-- they need a time zone to compare correctly when the database's isn't UTC.
+-- Existing rows were written in UTC.
+ALTER TABLE feed_fetches
+    ALTER COLUMN started_at TYPE TIMESTAMPTZ USING started_at AT TIME ZONE 'UTC',
+    ALTER COLUMN finished_at TYPE TIMESTAMPTZ USING finished_at AT TIME ZONE 'UTC';
+
+-- +goose Down
+
+ALTER TABLE feed_fetches
+    ALTER COLUMN started_at TYPE TIMESTAMP USING started_at AT TIME ZONE 'UTC',
+    ALTER COLUMN finished_at TYPE TIMESTAMP USING finished_at AT TIME ZONE 'UTC';
@@ -0,0 +1,10 @@
+-- +goose Up
+
+-- When the hub last verified a subscription request. A request made after
+-- it is still waiting for the hub.
+ALTER TABLE websub_subscriptions ADD COLUMN verified_at TIMESTAMP;
+UPDATE websub_subscriptions SET verified_at = updated_at WHERE lease_expires_at IS NOT NULL;
+
+-- +goose Down
+
+ALTER TABLE websub_subscriptions DROP COLUMN verified_at;
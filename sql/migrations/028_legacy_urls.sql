@@ -0,0 +1,20 @@
+-- +goose Up
+
+-- Posts and feeds stored before URLs were canonicalized. The scraper
+-- rewrites their URLs to canonical form, merging any duplicates, and
+-- removes them from here as it goes.
+CREATE TABLE legacy_post_urls (
+    post_id UUID PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE
+);
+INSERT INTO legacy_post_urls (post_id) SELECT id FROM posts;
+
+CREATE TABLE legacy_feed_urls (
+    feed_id UUID PRIMARY KEY REFERENCES feeds(id) ON DELETE CASCADE
+);
+INSERT INTO legacy_feed_urls (feed_id) SELECT id FROM feeds;
+
+-- +goose Down
+
+DROP TABLE legacy_feed_urls;
+
+DROP TABLE legacy_post_urls;
@@ -0,0 +1,32 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys (id, user_id, name, key_hash, prefix, scopes)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetApiKeysForUser :many
+SELECT * FROM api_keys
+WHERE user_id = $1
+AND (expires_at IS NULL OR expires_at > NOW())
+ORDER BY created_at;
+
+-- name: GetApiKey :one
+-- Locks the key until the end of the transaction, so that it can't be
+-- rotated twice at once.
+SELECT * FROM api_keys
+WHERE id = $1 AND user_id = $2
+AND (expires_at IS NULL OR expires_at > NOW())
+FOR UPDATE;
+
+-- name: DeleteApiKey :execrows
+DELETE FROM api_keys
+WHERE id = $1 AND user_id = $2;
+
+-- name: ExpireApiKey :exec
+-- Never extends a key that already expires sooner.
+UPDATE api_keys
+SET expires_at = LEAST(expires_at, NOW() + sqlc.arg(grace_seconds)::int * INTERVAL '1 second'), updated_at = NOW()
+WHERE id = sqlc.arg(id);
+
+-- name: DeleteExpiredApiKeys :exec
+DELETE FROM api_keys
+WHERE user_id = $1 AND expires_at <= NOW();
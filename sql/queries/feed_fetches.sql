@@ -0,0 +1,35 @@
+-- name: CreateFeedFetch :exec
+INSERT INTO feed_fetches (
+    id, feed_id, started_at, finished_at, http_status, bytes, duration_ms,
+    items_seen, posts_inserted, posts_updated, error
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+
+-- name: GetFeedFetches :many
+SELECT * FROM feed_fetches
+WHERE feed_id = $1
+ORDER BY started_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: DeleteFeedFetchesOlderThan :execrows
+DELETE FROM feed_fetches
+WHERE started_at < NOW() - sqlc.arg(retention_days)::int * INTERVAL '1 day';
+
+-- name: GetFeedFetchStats :one
+-- Counts over the fetch history still kept. Consecutive failures are
+-- those since the last successful fetch.
+SELECT
+    COUNT(*) AS fetches,
+    COUNT(*) FILTER (WHERE error IS NOT NULL) AS failures,
+    COUNT(*) FILTER (WHERE error IS NOT NULL AND started_at > COALESCE(
+        (SELECT MAX(ok.started_at) FROM feed_fetches AS ok WHERE ok.feed_id = $1 AND ok.error IS NULL),
+        '-infinity'
+    )) AS consecutive_failures
+FROM feed_fetches
+WHERE feed_id = $1;
+
+-- name: GetLastSuccessfulFeedFetch :one
+SELECT * FROM feed_fetches
+WHERE feed_id = $1 AND error IS NULL
+ORDER BY started_at DESC
+LIMIT 1;
@@ -8,3 +8,6 @@ SELECT * FROM feed_follows WHERE user_id = $1;
 
 -- name: DeleteFeedFollow :exec
 DELETE FROM feed_follows WHERE id = $1 AND user_id = $2;
+
+-- name: CountFeedFollowers :one
+SELECT COUNT(*) FROM feed_follows WHERE feed_id = $1;
@@ -1,16 +1,74 @@
 -- name: CreateFeed :one
-INSERT INTO feeds (id, name, url, user_id)
-VALUES ($1, $2, $3, $4)
+INSERT INTO feeds (id, name, url, user_id, original_url)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
 -- name: GetFeeds :many
 SELECT * FROM feeds;
 
--- name: GetNextFeedsToFetch :many
+-- name: GetFeed :one
+SELECT * FROM feeds
+WHERE id = $1;
+
+-- name: GetFeedForUser :one
+-- Returns the feed only if the user created or follows it.
 SELECT * FROM feeds
-ORDER BY last_fetched_at
-ASC NULLS FIRST LIMIT $1;
+WHERE id = sqlc.arg(id)
+AND (user_id = sqlc.arg(user_id) OR EXISTS (
+    SELECT 1 FROM feed_follows
+    WHERE feed_follows.feed_id = feeds.id AND feed_follows.user_id = sqlc.arg(user_id)
+));
+
+-- name: GetNextFeedsToFetch :many
+-- Feeds with an active WebSub lease get their updates pushed, so they are
+-- only polled every pushed_poll_seconds, or once the lease is about to end.
+SELECT feeds.* FROM feeds
+LEFT JOIN websub_subscriptions ON websub_subscriptions.feed_id = feeds.id
+WHERE websub_subscriptions.lease_expires_at IS NULL
+OR websub_subscriptions.lease_expires_at < NOW() + sqlc.arg(renew_within_seconds)::int * INTERVAL '1 second'
+OR feeds.last_fetched_at IS NULL
+OR feeds.last_fetched_at < NOW() - sqlc.arg(pushed_poll_seconds)::int * INTERVAL '1 second'
+ORDER BY feeds.last_fetched_at
+ASC NULLS FIRST LIMIT sqlc.arg('limit');
 
 -- name: MarkFeedAsFetched :exec
 UPDATE feeds SET last_fetched_at = NOW(), updated_at = NOW()
 WHERE id = $1 RETURNING *;
+
+-- name: SetFeedFullContentExtraction :one
+UPDATE feeds SET extract_full_content_since = CASE
+    WHEN NOT sqlc.arg(enabled)::boolean THEN NULL
+    ELSE COALESCE(extract_full_content_since, NOW())
+END, updated_at = NOW()
+WHERE id = sqlc.arg(id) AND user_id = sqlc.arg(user_id)
+RETURNING *;
+
+-- name: DeleteFeed :execrows
+DELETE FROM feeds WHERE id = $1;
+
+-- name: GetFeedsCreatedByUser :many
+SELECT * FROM feeds
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: GetFeedsFollowedByUser :many
+SELECT feeds.* FROM feeds
+JOIN feed_follows ON feed_follows.feed_id = feeds.id
+WHERE feed_follows.user_id = $1
+ORDER BY feed_follows.created_at;
+
+-- name: ReassignFeedsOfUser :execrows
+-- Hands each feed the user created to its longest-standing other follower.
+-- Feeds nobody else follows are left alone. The new owners' feeds quotas
+-- aren't checked: they already follow these feeds, so scraping costs no
+-- more than before, and refusing would leave the feeds without an owner.
+UPDATE feeds
+SET user_id = next_owner.user_id, updated_at = NOW()
+FROM (
+    SELECT DISTINCT ON (feed_follows.feed_id) feed_follows.feed_id, feed_follows.user_id
+    FROM feed_follows
+    JOIN feeds ON feeds.id = feed_follows.feed_id
+    WHERE feeds.user_id = $1 AND feed_follows.user_id <> $1
+    ORDER BY feed_follows.feed_id, feed_follows.created_at
+) AS next_owner
+WHERE feeds.id = next_owner.feed_id;
@@ -0,0 +1,45 @@
+-- name: GetLegacyPostUrls :many
+SELECT posts.id, posts.url FROM legacy_post_urls
+JOIN posts ON posts.id = legacy_post_urls.post_id
+LIMIT $1;
+
+-- name: SetPostUrl :execrows
+-- Changes nothing when another post already has the URL.
+UPDATE posts SET url = sqlc.arg(url), updated_at = NOW()
+WHERE id = sqlc.arg(id)
+AND NOT EXISTS (
+    SELECT 1 FROM posts AS other
+    WHERE other.url = sqlc.arg(url) AND other.id <> sqlc.arg(id)
+);
+
+-- name: DeletePost :exec
+DELETE FROM posts WHERE id = $1;
+
+-- name: DeleteLegacyPostUrl :exec
+DELETE FROM legacy_post_urls WHERE post_id = $1;
+
+-- name: GetLegacyFeedUrls :many
+SELECT feeds.id, feeds.url FROM legacy_feed_urls
+JOIN feeds ON feeds.id = legacy_feed_urls.feed_id
+LIMIT $1;
+
+-- name: GetFeedByUrl :one
+SELECT * FROM feeds WHERE url = $1;
+
+-- name: SetFeedUrl :exec
+UPDATE feeds SET url = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: MergeFeedFollows :exec
+-- Moves the follows of one feed to another, dropping those of users who
+-- follow both.
+UPDATE feed_follows SET feed_id = sqlc.arg(into_feed_id), updated_at = NOW()
+WHERE feed_id = sqlc.arg(from_feed_id)
+AND user_id NOT IN (SELECT user_id FROM feed_follows WHERE feed_id = sqlc.arg(into_feed_id));
+
+-- name: MergeFeedPosts :exec
+UPDATE posts SET feed_id = sqlc.arg(into_feed_id), updated_at = NOW()
+WHERE feed_id = sqlc.arg(from_feed_id);
+
+-- name: DeleteLegacyFeedUrl :exec
+DELETE FROM legacy_feed_urls WHERE feed_id = $1;
@@ -0,0 +1,20 @@
+-- name: CreatePasswordReset :one
+INSERT INTO password_resets (id, user_id, token_hash, expires_at)
+VALUES (
+    sqlc.arg(id),
+    sqlc.arg(user_id),
+    sqlc.arg(token_hash),
+    NOW() + sqlc.arg(ttl_seconds)::int * INTERVAL '1 second'
+)
+RETURNING *;
+
+-- name: ConsumePasswordReset :one
+-- Deletes an unexpired reset token and returns it, so each one can be
+-- used only once.
+DELETE FROM password_resets
+WHERE token_hash = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeletePasswordResetsForUser :exec
+DELETE FROM password_resets
+WHERE user_id = $1;
@@ -0,0 +1,15 @@
+-- name: SetPostCategories :exec
+-- Replaces the categories of a post with names, in one statement so that
+-- readers never see half of the change.
+WITH removed AS (
+    DELETE FROM post_categories
+    WHERE post_id = sqlc.arg(post_id) AND name <> ALL(sqlc.arg(names)::text[])
+)
+INSERT INTO post_categories (post_id, name)
+SELECT sqlc.arg(post_id), unnest(sqlc.arg(names)::text[])
+ON CONFLICT DO NOTHING;
+
+-- name: GetCategoriesForPosts :many
+SELECT * FROM post_categories
+WHERE post_id = ANY(sqlc.arg(post_ids)::uuid[])
+ORDER BY post_id, name;
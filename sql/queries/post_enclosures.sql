@@ -0,0 +1,9 @@
+-- name: CreatePostEnclosure :exec
+INSERT INTO post_enclosures (id, post_id, url, mime_type, length, duration_seconds)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (post_id, url) DO NOTHING;
+
+-- name: GetEnclosuresForPosts :many
+SELECT * FROM post_enclosures
+WHERE post_id = ANY(sqlc.arg(post_ids)::uuid[])
+ORDER BY post_id, created_at;
@@ -1,11 +1,121 @@
--- name: CreatePost :one
-INSERT INTO posts (id, feed_id, title, description, published_at, url)
-VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING *;
-
 -- name: GetPostsForUser :many
 SELECT posts.* FROM posts
 JOIN feed_follows ON posts.feed_id = feed_follows.feed_id
-WHERE feed_follows.user_id = $1
-ORDER BY posts.published_at DESC
-LIMIT $2;
+WHERE feed_follows.user_id = sqlc.arg(user_id)
+AND (sqlc.narg(enclosure_type)::text IS NULL OR EXISTS (
+    SELECT 1 FROM post_enclosures
+    WHERE post_enclosures.post_id = posts.id
+    AND post_enclosures.mime_type LIKE sqlc.narg(enclosure_type) || '%'
+))
+ORDER BY
+    CASE WHEN sqlc.arg(oldest_first)::boolean THEN posts.published_at END ASC,
+    CASE WHEN sqlc.arg(oldest_first)::boolean THEN posts.id END ASC,
+    posts.published_at DESC, posts.id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: GetClusteredPostsForUser :many
+SELECT posts.* FROM posts
+JOIN feed_follows ON posts.feed_id = feed_follows.feed_id
+WHERE feed_follows.user_id = sqlc.arg(user_id)
+AND (sqlc.narg(enclosure_type)::text IS NULL OR EXISTS (
+    SELECT 1 FROM post_enclosures
+    WHERE post_enclosures.post_id = posts.id
+    AND post_enclosures.mime_type LIKE sqlc.narg(enclosure_type) || '%'
+))
+AND NOT EXISTS (
+    SELECT 1 FROM posts AS newer
+    JOIN feed_follows AS newer_follows ON newer.feed_id = newer_follows.feed_id
+    WHERE newer_follows.user_id = sqlc.arg(user_id)
+    AND newer.cluster_id = posts.cluster_id
+    AND (sqlc.narg(enclosure_type)::text IS NULL OR EXISTS (
+        SELECT 1 FROM post_enclosures
+        WHERE post_enclosures.post_id = newer.id
+        AND post_enclosures.mime_type LIKE sqlc.narg(enclosure_type) || '%'
+    ))
+    AND (newer.published_at, newer.id) > (posts.published_at, posts.id)
+)
+ORDER BY
+    CASE WHEN sqlc.arg(oldest_first)::boolean THEN posts.published_at END ASC,
+    CASE WHEN sqlc.arg(oldest_first)::boolean THEN posts.id END ASC,
+    posts.published_at DESC, posts.id DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: GetPostsInClustersForUser :many
+SELECT posts.* FROM posts
+JOIN feed_follows ON posts.feed_id = feed_follows.feed_id
+WHERE feed_follows.user_id = sqlc.arg(user_id)
+AND posts.cluster_id = ANY(sqlc.arg(cluster_ids)::uuid[])
+ORDER BY posts.published_at DESC;
+
+-- name: FindSimilarPost :one
+-- Posts from the same feed are never clustered with each other.
+SELECT id, cluster_id FROM posts
+WHERE fingerprint IS NOT NULL
+AND published_at BETWEEN sqlc.arg(since) AND sqlc.arg(until)
+AND feed_id <> sqlc.arg(feed_id)
+AND bit_count((fingerprint # sqlc.arg(fingerprint)::bigint)::bit(64)) <= sqlc.arg(max_distance)::int
+ORDER BY bit_count((fingerprint # sqlc.arg(fingerprint)::bigint)::bit(64)), published_at
+LIMIT 1;
+
+-- name: SetPostCluster :exec
+UPDATE posts SET cluster_id = $2
+WHERE id = $1;
+
+-- name: GetUnsanitizedPosts :many
+SELECT * FROM posts
+WHERE (description IS NOT NULL AND description_raw IS NULL)
+OR (content IS NOT NULL AND content_raw IS NULL)
+LIMIT $1;
+
+-- name: UpdatePostSanitizedContent :exec
+UPDATE posts
+SET description = $2, description_raw = $3, content = $4, content_raw = $5, updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetPostsPendingFullContent :many
+SELECT posts.* FROM posts
+JOIN feeds ON posts.feed_id = feeds.id
+WHERE feeds.extract_full_content_since IS NOT NULL
+AND posts.created_at >= feeds.extract_full_content_since
+AND posts.full_content_fetched_at IS NULL
+ORDER BY posts.created_at
+LIMIT $1;
+
+-- name: UpdatePostFullContent :exec
+UPDATE posts SET full_content = $2, full_content_fetched_at = NOW()
+WHERE id = $1;
+
+-- name: UpsertPost :one
+-- Inserts a post, or updates the stored one from the same feed when its
+-- title or markup changed. Returns no row when nothing changed.
+INSERT INTO posts (
+    id, feed_id, title, description, published_at, url, content, author, comments_url, source_name, source_url,
+    itunes_episode, itunes_season, itunes_image, itunes_explicit, thumbnail_url, description_raw, content_raw,
+    summary, word_count, reading_time_minutes, original_url, fingerprint, cluster_id
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
+ON CONFLICT (url) DO UPDATE SET
+    title = EXCLUDED.title,
+    description = EXCLUDED.description,
+    content = EXCLUDED.content,
+    author = EXCLUDED.author,
+    comments_url = EXCLUDED.comments_url,
+    source_name = EXCLUDED.source_name,
+    source_url = EXCLUDED.source_url,
+    itunes_episode = EXCLUDED.itunes_episode,
+    itunes_season = EXCLUDED.itunes_season,
+    itunes_image = EXCLUDED.itunes_image,
+    itunes_explicit = EXCLUDED.itunes_explicit,
+    thumbnail_url = EXCLUDED.thumbnail_url,
+    description_raw = EXCLUDED.description_raw,
+    content_raw = EXCLUDED.content_raw,
+    summary = EXCLUDED.summary,
+    word_count = EXCLUDED.word_count,
+    reading_time_minutes = EXCLUDED.reading_time_minutes,
+    original_url = EXCLUDED.original_url,
+    fingerprint = EXCLUDED.fingerprint,
+    updated_at = NOW()
+WHERE posts.feed_id = EXCLUDED.feed_id
+AND (posts.title, posts.description_raw, posts.content_raw)
+    IS DISTINCT FROM (EXCLUDED.title, EXCLUDED.description_raw, EXCLUDED.content_raw)
+RETURNING id, (xmax = 0)::boolean AS inserted;
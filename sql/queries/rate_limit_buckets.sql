@@ -0,0 +1,23 @@
+-- name: TakeRateLimitToken :one
+-- Refills the bucket for the time since it was last used, then takes a
+-- token if a whole one is left. allowed tells whether one was taken.
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES (sqlc.arg(key), sqlc.arg(burst)::float8 - 1, TRUE, NOW())
+ON CONFLICT (key) DO UPDATE SET (tokens, allowed, updated_at) = (
+    SELECT
+        CASE WHEN refilled >= 1 THEN refilled - 1 ELSE refilled END,
+        refilled >= 1,
+        NOW()
+    FROM (
+        SELECT LEAST(
+            sqlc.arg(burst)::float8,
+            b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::float8 * sqlc.arg(per_second)::float8
+        ) AS refilled
+    ) AS bucket
+)
+RETURNING tokens, allowed;
+
+-- name: DeleteIdleRateLimitBuckets :exec
+-- Buckets left alone long enough are full, the same as no bucket at all.
+DELETE FROM rate_limit_buckets
+WHERE updated_at < NOW() - sqlc.arg(idle_seconds)::int * INTERVAL '1 second';
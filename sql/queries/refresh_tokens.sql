@@ -0,0 +1,36 @@
+-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (id, user_id, api_key_id, token_hash, scopes, expires_at)
+VALUES (
+    sqlc.arg(id),
+    sqlc.arg(user_id),
+    sqlc.narg(api_key_id),
+    sqlc.arg(token_hash),
+    sqlc.arg(scopes),
+    NOW() + sqlc.arg(ttl_seconds)::int * INTERVAL '1 second'
+)
+RETURNING *;
+
+-- name: ConsumeRefreshToken :one
+-- Deletes an unexpired refresh token and returns it, so each one can be
+-- exchanged only once. Tokens from an API key stop working once the key
+-- has expired.
+DELETE FROM refresh_tokens
+WHERE token_hash = $1 AND expires_at > NOW()
+AND (api_key_id IS NULL OR EXISTS (
+    SELECT 1 FROM api_keys
+    WHERE api_keys.id = refresh_tokens.api_key_id
+    AND (api_keys.expires_at IS NULL OR api_keys.expires_at > NOW())
+))
+RETURNING *;
+
+-- name: DeleteRefreshToken :execrows
+DELETE FROM refresh_tokens
+WHERE token_hash = $1;
+
+-- name: DeleteExpiredRefreshTokens :exec
+DELETE FROM refresh_tokens
+WHERE user_id = $1 AND expires_at <= NOW();
+
+-- name: DeleteRefreshTokensForUser :exec
+DELETE FROM refresh_tokens
+WHERE user_id = $1;
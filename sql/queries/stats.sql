@@ -0,0 +1,11 @@
+-- name: GetSystemStats :one
+SELECT
+    (SELECT COUNT(*) FROM users) AS users,
+    (SELECT COUNT(*) FROM users WHERE is_admin) AS admins,
+    (SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL) AS disabled_users,
+    (SELECT COUNT(*) FROM feeds) AS feeds,
+    (SELECT COUNT(*) FROM feeds WHERE last_fetched_at IS NULL) AS feeds_never_fetched,
+    (SELECT COUNT(*) FROM websub_subscriptions WHERE lease_expires_at > NOW()) AS websub_subscriptions,
+    (SELECT COUNT(*) FROM feed_follows) AS feed_follows,
+    (SELECT COUNT(*) FROM posts) AS posts,
+    (SELECT COUNT(*) FROM posts WHERE created_at > NOW() - INTERVAL '1 day') AS posts_last_day;
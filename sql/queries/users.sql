@@ -1,7 +1,93 @@
 -- name: CreateUser :one
-INSERT INTO users (id, name, api_key)
-VALUES ($1, $2, encode(sha256(random()::text::bytea), 'hex'))
+INSERT INTO users (id, name, email, password_hash)
+VALUES ($1, $2, $3, $4)
 RETURNING *;
 
+-- name: GetUser :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users WHERE email = $1;
+
+-- name: SetUserEmail :one
+UPDATE users
+SET email = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET name = $2, timezone = $3, page_size = $4, post_sort = $5,
+    display_preferences = $6, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserPassword :exec
+UPDATE users
+SET password_hash = $2, updated_at = NOW()
+WHERE id = $1;
+
 -- name: GetUserByApiKey :one
-SELECT * FROM users WHERE api_key = $1;
+-- Looks a user up by the SHA-256 hash of one of their unexpired API keys.
+-- Also returns the key's id and the scopes it grants. The key is marked as
+-- used at most once a minute, so that most requests authenticate without
+-- writing anything.
+WITH used_key AS (
+    SELECT id, user_id, scopes, last_used_at FROM api_keys
+    WHERE key_hash = $1
+    AND (expires_at IS NULL OR expires_at > NOW())
+), touched_key AS (
+    UPDATE api_keys SET last_used_at = NOW()
+    FROM used_key
+    WHERE api_keys.id = used_key.id
+    AND (used_key.last_used_at IS NULL OR used_key.last_used_at < NOW() - INTERVAL '1 minute')
+)
+SELECT sqlc.embed(users), used_key.id AS api_key_id, used_key.scopes FROM users
+JOIN used_key ON users.id = used_key.user_id;
+
+-- name: GetUsers :many
+SELECT * FROM users
+ORDER BY created_at
+LIMIT $1 OFFSET $2;
+
+-- name: SetUserAdmin :one
+UPDATE users
+SET is_admin = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserDisabled :one
+-- Disabling keeps the time it first happened.
+UPDATE users
+SET disabled_at = CASE
+    WHEN NOT sqlc.arg(disabled)::boolean THEN NULL
+    ELSE COALESCE(disabled_at, NOW())
+END, updated_at = NOW()
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1;
+
+-- name: PromoteUsersToAdmin :execrows
+UPDATE users
+SET is_admin = TRUE, updated_at = NOW()
+WHERE id = ANY(sqlc.arg(ids)::uuid[]) AND NOT is_admin;
+
+-- name: GetUserQuotaUsage :one
+-- Returns the user's quota overrides and how many feeds and follows they
+-- have. The user row stays locked until the end of the transaction, so
+-- concurrent creates are checked one after another.
+SELECT
+    users.max_feeds,
+    users.max_follows,
+    (SELECT COUNT(*) FROM feeds WHERE feeds.user_id = users.id) AS feeds,
+    (SELECT COUNT(*) FROM feed_follows WHERE feed_follows.user_id = users.id) AS follows
+FROM users
+WHERE users.id = $1
+FOR UPDATE OF users;
+
+-- name: SetUserQuotas :exec
+UPDATE users
+SET max_feeds = $2, max_follows = $3, updated_at = NOW()
+WHERE id = $1;
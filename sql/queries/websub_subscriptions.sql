@@ -0,0 +1,22 @@
+-- name: UpsertWebSubSubscription :one
+INSERT INTO websub_subscriptions (feed_id, hub_url, topic_url, secret)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (feed_id) DO UPDATE SET
+    hub_url = EXCLUDED.hub_url,
+    topic_url = EXCLUDED.topic_url,
+    requested_at = NOW(),
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetWebSubSubscription :one
+SELECT * FROM websub_subscriptions
+WHERE feed_id = $1;
+
+-- name: ConfirmWebSubSubscription :exec
+UPDATE websub_subscriptions
+SET lease_expires_at = NOW() + sqlc.arg(lease_seconds)::int * INTERVAL '1 second', verified_at = NOW(), updated_at = NOW()
+WHERE feed_id = sqlc.arg(feed_id);
+
+-- name: DeleteWebSubSubscription :exec
+DELETE FROM websub_subscriptions
+WHERE feed_id = $1;
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	summaryLength  = 280
+	wordsPerMinute = 200
+)
+
+type postSummary struct {
+	Excerpt            sql.NullString
+	WordCount          sql.NullInt32
+	ReadingTimeMinutes sql.NullInt32
+}
+
+// summarizePost builds a plain-text excerpt and reading stats from the
+// post's sanitized HTML, preferring the full content over the description.
+func summarizePost(description, content string) postSummary {
+	text := htmlToText(content)
+	if text == "" {
+		text = htmlToText(description)
+	}
+	if text == "" {
+		return postSummary{}
+	}
+
+	words := len(strings.Fields(text))
+	minutes := (words + wordsPerMinute - 1) / wordsPerMinute
+	return postSummary{
+		Excerpt:            sql.NullString{String: truncateText(text, summaryLength), Valid: true},
+		WordCount:          sql.NullInt32{Int32: int32(words), Valid: true},
+		ReadingTimeMinutes: sql.NullInt32{Int32: int32(minutes), Valid: true},
+	}
+}
+
+// textBreakElements are the elements that separate words on their own,
+// unlike inline ones such as <em> that can sit inside a word.
+var textBreakElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Br: true, atom.Caption: true, atom.Dd: true, atom.Details: true,
+	atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Figcaption: true,
+	atom.Figure: true, atom.Footer: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Hr: true, atom.Li: true,
+	atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true,
+	atom.Pre: true, atom.Section: true, atom.Summary: true, atom.Table: true,
+	atom.Td: true, atom.Th: true, atom.Tr: true, atom.Ul: true,
+}
+
+// htmlToText strips tags, decodes entities and collapses whitespace.
+// Block-level tags and <br> become word breaks so "<p>a</p><p>b</p>" reads
+// "a b", while inline tags don't: "foo<em>bar</em>" reads "foobar".
+func htmlToText(fragment string) string {
+	if fragment == "" {
+		return ""
+	}
+	var sb strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	skipDepth := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(sb.String()), " ")
+		case html.TextToken:
+			if skipDepth == 0 {
+				sb.Write(tokenizer.Text())
+			}
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style:
+				skipDepth++
+			}
+			writeTextBreak(&sb, name)
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style:
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			}
+			writeTextBreak(&sb, name)
+		case html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			writeTextBreak(&sb, name)
+		}
+	}
+}
+
+// writeTextBreak separates the text around block elements and br.
+func writeTextBreak(sb *strings.Builder, tagName []byte) {
+	if textBreakElements[atom.Lookup(tagName)] {
+		sb.WriteByte(' ')
+	}
+}
+
+// truncateText cuts text to at most limit runes, preferring a word
+// boundary, and marks the cut with an ellipsis.
+func truncateText(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	cut := string(runes[:limit])
+	if i := strings.LastIndexByte(cut, ' '); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
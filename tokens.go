@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/viniciuspra/rssagg/internal/auth"
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const (
+	minTokenSecretLength   = 32
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// bearerTokens issues short-lived signed access tokens, along with refresh
+// tokens to get new ones, and accepts the access tokens as
+// "Authorization: Bearer <token>". Access tokens aren't stored, so revoking
+// a key or a refresh token only stops new ones from being issued.
+type bearerTokens struct {
+	db         *db.Queries
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func newBearerTokens(dbQ *db.Queries, secret string, accessTTL, refreshTTL time.Duration) (*bearerTokens, error) {
+	if len(secret) < minTokenSecretLength {
+		return nil, fmt.Errorf("secret must be at least %d characters", minTokenSecretLength)
+	}
+	return &bearerTokens{
+		db:         dbQ,
+		secret:     []byte(secret),
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}, nil
+}
+
+func (t *bearerTokens) authenticate(r *http.Request) (identity, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if errors.Is(err, auth.ErrNoAuthHeader) || errors.Is(err, auth.ErrOtherScheme) {
+		return identity{}, errNoCredentials
+	}
+	if err != nil {
+		return identity{}, err
+	}
+
+	claims, err := auth.ParseAccessToken(t.secret, token)
+	if err != nil {
+		return identity{}, err
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return identity{}, auth.ErrInvalidToken
+	}
+	user, err := t.db.GetUser(r.Context(), userID)
+	if err != nil {
+		return identity{}, fmt.Errorf("couldn't get user: %w", err)
+	}
+	return identity{user: user, scopes: claims.Scopes()}, nil
+}
+
+// issue creates an access token and a refresh token for userID. apiKeyID is
+// the key the session started from, if any, so that revoking the key ends
+// the session too.
+func (t *bearerTokens) issue(ctx context.Context, dbQ *db.Queries, userID uuid.UUID, apiKeyID uuid.NullUUID, scopes []string) (Token, error) {
+	accessToken, _, err := auth.IssueAccessToken(t.secret, userID, scopes, t.accessTTL)
+	if err != nil {
+		return Token{}, err
+	}
+	refreshToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return Token{}, err
+	}
+
+	err = dbQ.DeleteExpiredRefreshTokens(ctx, userID)
+	if err != nil {
+		return Token{}, err
+	}
+	_, err = dbQ.CreateRefreshToken(ctx, db.CreateRefreshTokenParams{
+		ID:         uuid.New(),
+		UserID:     userID,
+		ApiKeyID:   apiKeyID,
+		TokenHash:  auth.HashApiKey(refreshToken),
+		Scopes:     scopes,
+		TtlSeconds: int32(t.refreshTTL / time.Second),
+	})
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(t.accessTTL / time.Second),
+		RefreshToken: refreshToken,
+		Scopes:       scopes,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/viniciuspra/rssagg/internal/db"
+)
+
+const (
+	defaultWebSubPollInterval = 12 * time.Hour
+	webSubLeaseSeconds        = 10 * 24 * 60 * 60
+	webSubRenewWithin         = 24 * time.Hour
+	webSubPendingTimeout      = time.Hour
+	// webSubMaxLeaseSeconds caps the leases hubs grant, so that a feed is
+	// resubscribed, and polled normally if that fails, at least this often.
+	webSubMaxLeaseSeconds = 30 * 24 * 60 * 60
+)
+
+// webSubClient subscribes feeds that advertise a WebSub hub so new items
+// are pushed to /v1/websub/{feedID} instead of waiting for the next poll.
+type webSubClient struct {
+	db           *db.Queries
+	client       *http.Client
+	callbackBase string
+	pollInterval time.Duration
+}
+
+func newWebSubClient(dbQ *db.Queries, fetcher *feedFetcher, callbackBase string, pollInterval time.Duration) *webSubClient {
+	return &webSubClient{
+		db:           dbQ,
+		client:       fetcher.client,
+		callbackBase: strings.TrimRight(callbackBase, "/"),
+		pollInterval: pollInterval,
+	}
+}
+
+// ensureSubscribed (re)subscribes feed to the hub it advertises unless a
+// lease with the same hub and topic is still good for a while, or a request
+// is already waiting for the hub to verify it.
+func (c *webSubClient) ensureSubscribed(ctx context.Context, feed db.Feed, rssFeed RSSFeed) error {
+	hubURL, topicURL := rssFeed.webSubLinks(feed.OriginalUrl)
+	if hubURL == "" {
+		return nil
+	}
+	if topicURL == "" {
+		topicURL = feed.OriginalUrl
+	}
+
+	secret := ""
+	subscription, err := c.db.GetWebSubSubscription(ctx, feed.ID)
+	switch {
+	case err == nil:
+		sameTarget := subscription.HubUrl == hubURL && subscription.TopicUrl == topicURL
+		if sameTarget && subscription.LeaseExpiresAt.Valid && time.Until(subscription.LeaseExpiresAt.Time) > webSubRenewWithin {
+			return nil
+		}
+		if sameTarget && !subscription.LeaseExpiresAt.Valid && time.Since(subscription.RequestedAt) < webSubPendingTimeout {
+			return nil
+		}
+		secret = subscription.Secret
+	case errors.Is(err, sql.ErrNoRows):
+		secret = rand.Text()
+	default:
+		return err
+	}
+
+	subscription, err = c.db.UpsertWebSubSubscription(ctx, db.UpsertWebSubSubscriptionParams{
+		FeedID:   feed.ID,
+		HubUrl:   hubURL,
+		TopicUrl: topicURL,
+		Secret:   secret,
+	})
+	if err != nil {
+		return err
+	}
+	return c.subscribe(ctx, subscription)
+}
+
+// webSubPending reports whether a subscription request is waiting for the
+// hub to verify it. Verifications of anything else didn't come from a hub
+// we asked.
+func webSubPending(subscription db.WebsubSubscription) bool {
+	if subscription.VerifiedAt.Valid && !subscription.VerifiedAt.Time.Before(subscription.RequestedAt) {
+		return false
+	}
+	return time.Since(subscription.RequestedAt) < webSubPendingTimeout
+}
+
+func (c *webSubClient) subscribe(ctx context.Context, subscription db.WebsubSubscription) error {
+	form := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {subscription.TopicUrl},
+		"hub.callback":      {c.callbackBase + "/v1/websub/" + subscription.FeedID.String()},
+		"hub.secret":        {subscription.Secret},
+		"hub.lease_seconds": {strconv.Itoa(webSubLeaseSeconds)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.HubUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hub %v refused subscription: %v", subscription.HubUrl, resp.Status)
+	}
+	return nil
+}
+
+// validWebSubSignature checks an X-Hub-Signature header ("sha1=<hex>",
+// "sha256=<hex>", ...) against the HMAC of body keyed with secret.
+func validWebSubSignature(header string, body []byte, secret string) bool {
+	method, signature, ok := strings.Cut(header, "=")
+	if !ok {
+		return false
+	}
+	var newHash func() hash.Hash
+	switch strings.ToLower(method) {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}